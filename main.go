@@ -11,7 +11,9 @@ import (
 
 import (
 	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/data"
+	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/exec"
 	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/http"
+	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/middleware"
 )
 
 func main() {
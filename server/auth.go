@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
+)
+
+// HeaderAPIKey is the header of the api key
+const HeaderAPIKey = "X-API-Key"
+
+// Permissions are what a caller could do while converting, the empty lists
+// allow all
+type Permissions struct {
+	Fetchers       []string
+	Templates      []string
+	MaxOutputSize  int64 // 0 is unlimited
+	MaxConcurrency int   // 0 is unlimited
+	AllowURI       bool  // Convert converter.uri directly without fetcher
+	AllowExtend    bool  // Pass the extend flags to wkhtmltox
+}
+
+func newPermissions(conf config.Configuration) Permissions {
+	return Permissions{
+		Fetchers:       conf.GetStringList("fetchers"),
+		Templates:      conf.GetStringList("templates"),
+		MaxOutputSize:  conf.GetInt64("max-output-size", 0),
+		MaxConcurrency: int(conf.GetInt32("max-concurrency", 0)),
+		AllowURI:       conf.GetBoolean("allow-uri", false),
+		AllowExtend:    conf.GetBoolean("allow-extend", false),
+	}
+}
+
+// merge grants the permissions of both, e.g. a token with several roles
+func (p Permissions) merge(o Permissions) Permissions {
+	return Permissions{
+		Fetchers:       mergeAllowed(p.Fetchers, o.Fetchers),
+		Templates:      mergeAllowed(p.Templates, o.Templates),
+		MaxOutputSize:  mergeLimit(p.MaxOutputSize, o.MaxOutputSize),
+		MaxConcurrency: int(mergeLimit(int64(p.MaxConcurrency), int64(o.MaxConcurrency))),
+		AllowURI:       p.AllowURI || o.AllowURI,
+		AllowExtend:    p.AllowExtend || o.AllowExtend,
+	}
+}
+
+// mergeAllowed joins the allowed lists, the empty list allows all
+func mergeAllowed(a, b []string) []string {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+
+	ret := append([]string{}, a...)
+	for _, v := range b {
+		if !contains(a, v) {
+			ret = append(ret, v)
+		}
+	}
+
+	return ret
+}
+
+// mergeLimit returns the looser limit, 0 is unlimited
+func mergeLimit(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// Principal is the authenticated caller, by api key or bearer token
+type Principal struct {
+	Name        string
+	Permissions Permissions
+}
+
+// authorize checks the convert request against the permissions
+func (p *Principal) authorize(args ConvertArgs, opts wkhtmltox.ConvertOptions) (err error) {
+
+	perms := p.Permissions
+
+	fetcherName := args.Fetcher.Name
+	direct := len(fetcherName) == 0 || fetcherName == "default"
+
+	if !direct && !contains(perms.Fetchers, fetcherName) {
+		err = fmt.Errorf("fetcher %s is not permitted", fetcherName)
+		return
+	}
+
+	if len(args.Template) > 0 && !contains(perms.Templates, args.Template) {
+		err = fmt.Errorf("template %s is not permitted", args.Template)
+		return
+	}
+
+	var uri string
+	var extend wkhtmltox.ExtendParams
+
+	switch o := opts.(type) {
+	case *wkhtmltox.ToImageOptions:
+		uri, extend = o.URI, o.Extend
+	case *wkhtmltox.ToPDFOptions:
+		uri, extend = o.URI, o.Extend
+	}
+
+	if direct && len(uri) > 0 && !perms.AllowURI {
+		err = fmt.Errorf("converting uri directly is not permitted")
+		return
+	}
+
+	if len(extend) > 0 && !perms.AllowExtend {
+		err = fmt.Errorf("extend flags are not permitted")
+		return
+	}
+
+	return
+}
+
+func contains(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext returns nil while the auth is disabled
+func principalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// apiKeys are the principals by the sha256 of their keys
+type apiKeys map[string]*Principal
+
+// newAPIKeys loads the keys of service.auth.keys and the keys of the store
+// file service.auth.keys-file, the store only has the hashed keys
+func newAPIKeys(conf config.Configuration) (keys apiKeys, err error) {
+
+	keys = apiKeys{}
+
+	err = keys.load(conf.GetConfig("keys"), true)
+	if err != nil {
+		return
+	}
+
+	if file := conf.GetString("keys-file"); len(file) > 0 {
+		storeConf := config.NewConfig(config.ConfigFile(file))
+
+		err = keys.load(storeConf.GetConfig("keys"), false)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (p apiKeys) load(conf config.Configuration, allowPlain bool) (err error) {
+	if conf == nil {
+		return
+	}
+
+	for _, name := range conf.Keys() {
+		keyConf := conf.GetConfig(name)
+
+		hash := strings.ToLower(keyConf.GetString("key-sha256"))
+
+		if plain := keyConf.GetString("key"); len(plain) > 0 {
+			if !allowPlain {
+				err = fmt.Errorf("[server]: api key %s of the key store should be hashed by key-sha256", name)
+				return
+			}
+			sum := sha256.Sum256([]byte(plain))
+			hash = hex.EncodeToString(sum[:])
+		}
+
+		if len(hash) != sha256.Size*2 {
+			err = fmt.Errorf("[server]: api key %s has no key or key-sha256", name)
+			return
+		}
+
+		if _, exist := p[hash]; exist {
+			err = fmt.Errorf("[server]: api key %s is duplicated", name)
+			return
+		}
+
+		p[hash] = &Principal{Name: name, Permissions: newPermissions(keyConf)}
+	}
+
+	return
+}
+
+func (p apiKeys) lookup(key string) *Principal {
+	sum := sha256.Sum256([]byte(key))
+	return p[hex.EncodeToString(sum[:])]
+}
+
+// concurrency limits the concurrent requests of every principal
+type concurrency struct {
+	locker sync.Mutex
+	slots  map[string]chan struct{}
+}
+
+// acquire takes a slot of the principal, false is returned while all the
+// slots are in use
+func (p *concurrency) acquire(principal *Principal) (release func(), ok bool) {
+
+	max := principal.Permissions.MaxConcurrency
+	if max <= 0 {
+		return func() {}, true
+	}
+
+	// the slots are keyed by the limit too, the requests holding the slots
+	// of the old limit release them to the old channel while it changes
+	key := fmt.Sprintf("%s/%d", principal.Name, max)
+
+	p.locker.Lock()
+	slots, exist := p.slots[key]
+	if !exist {
+		slots = make(chan struct{}, max)
+		p.slots[key] = slots
+	}
+	p.locker.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// authMiddleware authenticates the convert requests and the protected
+// requests, e.g. /readyz and /metrics, the other requests are passed through
+type authMiddleware struct {
+	path        string
+	protected   map[string]bool // any valid credential is enough, the permissions are not checked
+	keys        apiKeys
+	jwt         *jwtVerifier // nil while the bearer tokens are not accepted
+	concurrency *concurrency
+}
+
+// newAuthMiddleware creates the middleware of the convert path, the
+// protected paths are open while they are listed in auth.open-paths
+func newAuthMiddleware(conf config.Configuration, path string, protected ...string) (m *authMiddleware, err error) {
+
+	keys, err := newAPIKeys(conf)
+	if err != nil {
+		return
+	}
+
+	verifier, err := newJWTVerifier(conf.GetConfig("jwt"))
+	if err != nil {
+		return
+	}
+
+	open := map[string]bool{}
+	for _, p := range conf.GetStringList("open-paths") {
+		open[p] = true
+	}
+
+	protectedPaths := map[string]bool{}
+	for _, p := range protected {
+		if !open[p] {
+			protectedPaths[p] = true
+		}
+	}
+
+	m = &authMiddleware{
+		path:        path,
+		protected:   protectedPaths,
+		keys:        keys,
+		jwt:         verifier,
+		concurrency: &concurrency{slots: map[string]chan struct{}{}},
+	}
+
+	return
+}
+
+// authenticate returns nil principal with the reason while the credential
+// is missing or invalid
+func (p *authMiddleware) authenticate(req *http.Request) (principal *Principal, reason string) {
+
+	reason = "credential is missing or invalid"
+
+	if authorization := req.Header.Get("Authorization"); len(authorization) > 0 && p.jwt != nil {
+		// the scheme is case insensitive, rfc 7235
+		parts := strings.SplitN(strings.TrimSpace(authorization), " ", 2)
+
+		// the other schemes, e.g. Basic of a proxy, fall back to the api key
+		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			token := strings.TrimSpace(parts[1])
+
+			var err error
+			principal, err = p.jwt.verify(token)
+			if err != nil {
+				return nil, err.Error()
+			}
+
+			return
+		}
+
+		reason = "authorization scheme should be Bearer"
+	}
+
+	if key := req.Header.Get(HeaderAPIKey); len(key) > 0 {
+		if principal = p.keys.lookup(key); principal != nil {
+			return
+		}
+	}
+
+	return nil, reason
+}
+
+func (p *authMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+
+	if req.URL.Path != p.path && !p.protected[req.URL.Path] {
+		next(rw, req)
+		return
+	}
+
+	principal, reason := p.authenticate(req)
+
+	if principal == nil {
+		if p.jwt != nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="go-wkhtmltox"`)
+		} else {
+			rw.Header().Set("WWW-Authenticate", `ApiKey header="`+HeaderAPIKey+`"`)
+		}
+		writeError(rw, req, errUnauthorized, reason)
+		return
+	}
+
+	if req.URL.Path != p.path {
+		next(rw, req)
+		return
+	}
+
+	release, ok := p.concurrency.acquire(principal)
+	if !ok {
+		writeError(rw, req, errTooManyRequests, "too many concurrent requests of "+principal.Name)
+		return
+	}
+
+	defer release()
+
+	ctx := withPrincipal(req.Context(), principal)
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("principal", principal.Name))
+
+	next(rw, req.WithContext(ctx))
+}
+
+// writeError writes the error before the convert args are decoded
+func writeError(rw http.ResponseWriter, req *http.Request, kind wkhtmltox.ErrorKind, message string) {
+	writeResp(rw, req, ConvertArgs{}, ConvertResponse{
+		Code:    errorStatus[kind],
+		Message: message,
+		Error:   kind,
+	})
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/gogap/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtVerifier validates the bearer tokens of the identity provider by the
+// static keys, and maps the roles of the claims to the permissions
+type jwtVerifier struct {
+	keys       map[string]crypto.PublicKey // by kid, the keys of pem files have no kid
+	parser     *jwt.Parser
+	subject    string
+	rolesClaim string
+	roles      map[string]Permissions
+	defaults   []string // the roles of the tokens without any known role
+}
+
+// newJWTVerifier returns nil while service.auth.jwt is not set
+func newJWTVerifier(conf config.Configuration) (v *jwtVerifier, err error) {
+
+	if conf == nil || !conf.GetBoolean("enabled", true) {
+		return
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, file := range conf.GetStringList("jwks-files") {
+		err = loadJWKS(file, keys)
+		if err != nil {
+			return
+		}
+	}
+
+	for i, file := range conf.GetStringList("public-keys") {
+		var key crypto.PublicKey
+		key, err = loadPublicKey(file)
+		if err != nil {
+			return
+		}
+		keys[fmt.Sprintf("pem-%d", i)] = key
+	}
+
+	if len(keys) == 0 {
+		err = fmt.Errorf("[server]: jwt has no jwks-files or public-keys")
+		return
+	}
+
+	// the tokens issued for the other services by the same provider must
+	// not be accepted
+	issuer := conf.GetString("issuer")
+	audience := conf.GetString("audience")
+
+	if len(issuer) == 0 || len(audience) == 0 {
+		err = fmt.Errorf("[server]: jwt issuer and audience are required")
+		return
+	}
+
+	algorithms := conf.GetStringList("algorithms")
+	if len(algorithms) == 0 {
+		algorithms = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithLeeway(conf.GetTimeDuration("clock-skew", 30*time.Second)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+	}
+
+	roles := map[string]Permissions{}
+
+	if rolesConf := conf.GetConfig("roles"); rolesConf != nil {
+		for _, name := range rolesConf.Keys() {
+			roles[name] = newPermissions(rolesConf.GetConfig(name))
+		}
+	}
+
+	defaults := conf.GetStringList("default-roles")
+
+	for _, role := range defaults {
+		if _, exist := roles[role]; !exist {
+			err = fmt.Errorf("[server]: jwt default role %s not exist", role)
+			return
+		}
+	}
+
+	v = &jwtVerifier{
+		keys:       keys,
+		parser:     jwt.NewParser(opts...),
+		subject:    conf.GetString("subject-claim", "sub"),
+		rolesClaim: conf.GetString("roles-claim", "roles"),
+		roles:      roles,
+		defaults:   defaults,
+	}
+
+	return
+}
+
+func loadJWKS(file string, keys map[string]crypto.PublicKey) (err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	set := jose.JSONWebKeySet{}
+
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		err = fmt.Errorf("[server]: parse jwks %s failure, %s", file, err.Error())
+		return
+	}
+
+	for i, key := range set.Keys {
+		if !key.IsPublic() {
+			err = fmt.Errorf("[server]: jwks %s has non public key", file)
+			return
+		}
+
+		kid := key.KeyID
+		if len(kid) == 0 {
+			kid = fmt.Sprintf("%s-%d", file, i)
+		}
+
+		keys[kid] = key.Key
+	}
+
+	return
+}
+
+func loadPublicKey(file string) (key crypto.PublicKey, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	if key, err = jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return
+	}
+
+	if key, err = jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return
+	}
+
+	if key, err = jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return
+	}
+
+	err = fmt.Errorf("[server]: public key %s is not a rsa, ecdsa or ed25519 pem", file)
+
+	return
+}
+
+func (p *jwtVerifier) verify(tokenString string) (principal *Principal, err error) {
+
+	claims := jwt.MapClaims{}
+
+	_, err = p.parser.ParseWithClaims(tokenString, claims, p.keyFunc)
+	if err != nil {
+		err = fmt.Errorf("invalid bearer token, %s", err.Error())
+		return
+	}
+
+	subject, _ := claims[p.subject].(string)
+	if len(subject) == 0 {
+		err = fmt.Errorf("invalid bearer token, claim %s is empty", p.subject)
+		return
+	}
+
+	perms := p.permissions(claimRoles(claims[p.rolesClaim]))
+	if perms == nil {
+		perms = p.permissions(p.defaults)
+	}
+
+	if perms == nil {
+		err = fmt.Errorf("invalid bearer token, no role is granted to %s", subject)
+		return
+	}
+
+	principal = &Principal{Name: "jwt:" + subject, Permissions: *perms}
+
+	return
+}
+
+// permissions merges the permissions of the known roles, it is nil while
+// none of the roles is known
+func (p *jwtVerifier) permissions(roles []string) (perms *Permissions) {
+	for _, role := range roles {
+		rolePerms, exist := p.roles[role]
+		if !exist {
+			continue
+		}
+
+		if perms == nil {
+			perms = &rolePerms
+		} else {
+			merged := perms.merge(rolePerms)
+			perms = &merged
+		}
+	}
+	return
+}
+
+// keyFunc finds the key by kid, the token without kid is verified by the
+// only key
+func (p *jwtVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if len(kid) > 0 {
+		if key, exist := p.keys[kid]; exist {
+			return key, nil
+		}
+		return nil, fmt.Errorf("key %s not exist", kid)
+	}
+
+	if len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("token has no kid")
+}
+
+// claimRoles reads the roles by a list, or by a space separated string as the
+// scope of oauth2
+func claimRoles(v interface{}) (roles []string) {
+	switch r := v.(type) {
+	case string:
+		roles = strings.Fields(r)
+	case []interface{}:
+		for _, item := range r {
+			if s, ok := item.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+	return
+}
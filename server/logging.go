@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
+	"github.com/pborman/uuid"
+	"github.com/urfave/negroni"
+)
+
+// loggingMiddleware assigns the request id, binds the logger of the request
+// to the context and writes the access log
+type loggingMiddleware struct {
+	logger      *slog.Logger
+	debugLogger *slog.Logger // nil while the per-request debug is not allowed
+}
+
+func newLoggingMiddleware(conf config.Configuration, w io.Writer) (m *loggingMiddleware, err error) {
+
+	format := logging.FormatJSON
+	level := "info"
+	allowDebug := false
+
+	if conf != nil {
+		format = conf.GetString("format", format)
+		level = conf.GetString("level", level)
+		allowDebug = conf.GetBoolean("allow-debug", false)
+	}
+
+	logger, err := logging.New(w, format, level)
+	if err != nil {
+		return
+	}
+
+	m = &loggingMiddleware{logger: logger}
+
+	if allowDebug {
+		m.debugLogger, err = logging.New(w, format, "debug")
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (p *loggingMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+
+	start := time.Now()
+
+	requestID := req.Header.Get(logging.HeaderRequestID)
+	if !logging.ValidRequestID(requestID) {
+		requestID = uuid.New()
+	}
+
+	rw.Header().Set(logging.HeaderRequestID, requestID)
+
+	logger := p.logger
+	if p.debugLogger != nil {
+		if debug, _ := strconv.ParseBool(req.Header.Get(logging.HeaderDebug)); debug {
+			logger = p.debugLogger
+		}
+	}
+
+	logger = logger.With("request_id", requestID)
+
+	ctx := logging.WithRequestID(req.Context(), requestID)
+	ctx = logging.WithLogger(ctx, logger)
+
+	next(rw, req.WithContext(ctx))
+
+	status := http.StatusOK
+	if nrw, ok := rw.(negroni.ResponseWriter); ok && nrw.Status() != 0 {
+		status = nrw.Status()
+	}
+
+	logger.Info("request",
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+		"remote_addr", req.RemoteAddr,
+	)
+}
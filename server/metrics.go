@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/negroni"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wkhtmltox",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Count of the convert requests by target, fetcher, template, status and error class.",
+	}, []string{"target", "fetcher", "template", "code", "error"})
+
+	requestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the convert requests.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"target", "fetcher", "template"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDurationSeconds)
+}
+
+type requestLabelsKey struct{}
+
+// requestLabels are filled by the handler after the request body is decoded
+type requestLabels struct {
+	target   string
+	fetcher  string
+	template string
+	error    string
+}
+
+func setRequestLabels(req *http.Request, args ConvertArgs, resp ConvertResponse) {
+	labels, ok := req.Context().Value(requestLabelsKey{}).(*requestLabels)
+	if !ok {
+		return
+	}
+
+	// the values are limited, the labels are not created by the illegal requests
+	switch to := strings.ToLower(args.To); to {
+	case "pdf", "image":
+		labels.target = to
+	default:
+		labels.target = "invalid"
+	}
+
+	switch {
+	case len(args.Fetcher.Name) == 0 || args.Fetcher.Name == "default":
+		labels.fetcher = "default"
+	case htmlToX.HasFetcher(args.Fetcher.Name):
+		labels.fetcher = args.Fetcher.Name
+	default:
+		labels.fetcher = "unknown"
+	}
+
+	if _, exist := renderTmpls[args.Template]; exist {
+		labels.template = args.Template
+	} else {
+		labels.template = "default"
+	}
+
+	labels.error = string(resp.Error)
+}
+
+// metricsMiddleware observes the convert requests, the other requests are
+// passed through
+type metricsMiddleware struct {
+	path string
+}
+
+func (p *metricsMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+
+	if req.URL.Path != p.path {
+		next(rw, req)
+		return
+	}
+
+	labels := &requestLabels{}
+
+	start := time.Now()
+
+	next(rw, req.WithContext(context.WithValue(req.Context(), requestLabelsKey{}, labels)))
+
+	code := "200"
+	if nrw, ok := rw.(negroni.ResponseWriter); ok {
+		code = strconv.Itoa(nrw.Status())
+	}
+
+	target := labelValue(labels.target)
+	fetcher := labelValue(labels.fetcher)
+	template := labelValue(labels.template)
+
+	requestsTotal.WithLabelValues(target, fetcher, template, code, labelValue(labels.error)).Inc()
+	requestDurationSeconds.WithLabelValues(target, fetcher, template).Observe(time.Since(start).Seconds())
+}
+
+func labelValue(v string) string {
+	if len(v) == 0 {
+		return "none"
+	}
+	return v
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+)
+
+type clientKey struct{}
+
+// clientMiddleware identifies the client of the request for the rate limit
+// and the fair queue, it is the principal while the request is authenticated,
+// or the ip of the client
+type clientMiddleware struct {
+	header string // the header set by the trusted proxies, e.g. X-Forwarded-For
+	hops   int    // the count of the trusted proxies appending to the header
+}
+
+func newClientMiddleware(conf config.Configuration) *clientMiddleware {
+	m := &clientMiddleware{}
+
+	if conf != nil {
+		m.header = conf.GetString("client-ip-header")
+		m.hops = int(conf.GetInt32("trusted-proxies", 1))
+	}
+
+	return m
+}
+
+func (p *clientMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	ctx := context.WithValue(req.Context(), clientKey{}, p.clientOf(req))
+	next(rw, req.WithContext(ctx))
+}
+
+func (p *clientMiddleware) clientOf(req *http.Request) string {
+
+	if principal := principalFromContext(req.Context()); principal != nil {
+		return principal.Name
+	}
+
+	if len(p.header) > 0 && p.hops > 0 {
+		// the proxies append to the header, the entries before the ones of
+		// the trusted proxies are sent by the client
+		var entries []string
+		for _, v := range req.Header.Values(p.header) {
+			entries = append(entries, strings.Split(v, ",")...)
+		}
+
+		if len(entries) >= p.hops {
+			if ip := strings.TrimSpace(entries[len(entries)-p.hops]); len(ip) > 0 {
+				return "ip:" + ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// clientOf returns the client identified by clientMiddleware
+func clientOf(req *http.Request) string {
+	client, _ := req.Context().Value(clientKey{}).(string)
+	return client
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is the token bucket of every client
+type rateLimiter struct {
+	locker sync.Mutex
+
+	rate  float64 // tokens per second
+	burst float64
+
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns nil while the rate limit is disabled, options:
+//
+//	enabled = true
+//	rate    = 60 # requests per period
+//	period  = 1m
+//	burst   = 10 # default is rate
+func newRateLimiter(conf config.Configuration) *rateLimiter {
+
+	if conf == nil || !conf.GetBoolean("enabled", false) {
+		return nil
+	}
+
+	rate := float64(conf.GetInt64("rate", 60))
+	period := conf.GetTimeDuration("period", time.Minute)
+	burst := float64(conf.GetInt64("burst", int64(rate)))
+
+	if rate <= 0 || period <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate / period.Seconds(),
+		burst:   burst,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// take takes a token of the client, returns the remaining tokens, and the
+// duration until the bucket is full, or until a token is available while
+// it is refused
+func (p *rateLimiter) take(client string, now time.Time) (ok bool, remaining int, reset time.Duration) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.sweep(now)
+
+	b, exist := p.buckets[client]
+	if !exist {
+		b = &bucket{tokens: p.burst, last: now}
+		p.buckets[client] = b
+	}
+
+	b.tokens = math.Min(p.burst, b.tokens+now.Sub(b.last).Seconds()*p.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		ok = true
+		b.tokens--
+		reset = p.wait(p.burst - b.tokens)
+	} else {
+		reset = p.wait(1 - b.tokens)
+	}
+
+	remaining = int(b.tokens)
+
+	return
+}
+
+func (p *rateLimiter) wait(tokens float64) time.Duration {
+	return time.Duration(tokens / p.rate * float64(time.Second))
+}
+
+// sweep removes the full buckets, they are the same as the new ones
+func (p *rateLimiter) sweep(now time.Time) {
+
+	if now.Sub(p.lastSweep) < time.Minute {
+		return
+	}
+
+	p.lastSweep = now
+
+	for client, b := range p.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*p.rate >= p.burst {
+			delete(p.buckets, client)
+		}
+	}
+}
+
+// rateLimitMiddleware limits the convert requests of every client, and
+// reports the limits by the RateLimit-* headers
+type rateLimitMiddleware struct {
+	path    string
+	limiter *rateLimiter
+}
+
+func (p *rateLimitMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+
+	if req.URL.Path != p.path {
+		next(rw, req)
+		return
+	}
+
+	ok, remaining, reset := p.limiter.take(clientOf(req), time.Now())
+
+	resetSeconds := int(math.Ceil(reset.Seconds()))
+	window := int(math.Ceil(p.limiter.burst / p.limiter.rate))
+
+	header := rw.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(int(p.limiter.burst)))
+	header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+	header.Set("RateLimit-Policy", strconv.Itoa(int(p.limiter.burst))+";w="+strconv.Itoa(window))
+
+	if !ok {
+		header.Set("Retry-After", strconv.Itoa(resetSeconds))
+		writeError(rw, req, errTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	next(rw, req)
+}
+
+// withClient schedules the conversions of the request fairly with the other
+// clients in the converting queue
+func withClient(req *http.Request) *http.Request {
+	return req.WithContext(wkhtmltox.WithClient(req.Context(), clientOf(req)))
+}
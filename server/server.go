@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,27 +21,90 @@ import (
 	"github.com/TV4/graceful"
 	"github.com/gogap/config"
 	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
 	"github.com/gorilla/mux"
 	"github.com/phyber/negroni-gzip/gzip"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	"github.com/spf13/cast"
 	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	defaultTemplateText = `{"code":{{.Code}},"message":"{{.Message}}"{{if .Result}},"result":{{.Result|jsonify}}{{end}}}`
+	defaultTemplateText = `{"code":{{.Code}},"message":{{.Message|jsonify}}{{if .Error}},"error":{{.Error|jsonify}}{{end}}{{if .Result}},"result":{{.Result|jsonify}}{{end}}}`
+
+	// statusClientClosedRequest is used while the client canceled the request
+	statusClientClosedRequest = 499
+)
+
+// the error codes of the server, the others are the kinds of wkhtmltox.Error
+const (
+	errUnauthorized    wkhtmltox.ErrorKind = "unauthorized"      // The credential is missing or invalid
+	errForbidden       wkhtmltox.ErrorKind = "forbidden"         // The request is not permitted to the caller
+	errTooManyRequests wkhtmltox.ErrorKind = "too_many_requests" // The caller reaches its limits
+	errRequestTooLarge wkhtmltox.ErrorKind = "request_too_large" // The request body exceeds service.max-request-size
 )
 
+var errorStatus = map[wkhtmltox.ErrorKind]int{
+	wkhtmltox.ErrInvalidOptions:      http.StatusBadRequest,
+	wkhtmltox.ErrFetchFailed:         http.StatusBadGateway,
+	wkhtmltox.ErrTimeout:             http.StatusGatewayTimeout,
+	wkhtmltox.ErrCanceled:            statusClientClosedRequest,
+	wkhtmltox.ErrRendererFailed:      http.StatusInternalServerError,
+	wkhtmltox.ErrNetworkErrorInPage:  http.StatusBadGateway,
+	wkhtmltox.ErrInsufficientStorage: http.StatusInsufficientStorage,
+	wkhtmltox.ErrQueueFull:           http.StatusServiceUnavailable,
+	wkhtmltox.ErrInputTooLarge:       http.StatusRequestEntityTooLarge,
+	wkhtmltox.ErrOutputTooLarge:      http.StatusRequestEntityTooLarge,
+	wkhtmltox.ErrTooManyPages:        http.StatusRequestEntityTooLarge,
+	wkhtmltox.ErrInternal:            http.StatusInternalServerError,
+
+	errUnauthorized:    http.StatusUnauthorized,
+	errForbidden:       http.StatusForbidden,
+	errTooManyRequests: http.StatusTooManyRequests,
+	errRequestTooLarge: http.StatusRequestEntityTooLarge,
+}
+
 var (
 	htmlToX *wkhtmltox.WKHtmlToX
 
+	// maxRequestSize limits the body of the convert requests, 0 is unlimited
+	maxRequestSize int64
+
 	renderTmpls = make(map[string]*template.Template)
 
 	defaultTmpl *template.Template
 )
 
 type ConvertData struct {
-	Data []byte `json:"data"`
+	Data           []byte   `json:"data"`
+	Warnings       []string `json:"warnings,omitempty"` // The resources failed to load while the error policy tolerates them
+	ContentType    string   `json:"content_type"`
+	Size           int      `json:"size"`
+	Width          int      `json:"width,omitempty"`  // Image only
+	Height         int      `json:"height,omitempty"` // Image only
+	Pages          int      `json:"pages,omitempty"`  // PDF only
+	FetchDuration  int64    `json:"fetch_duration"`   // Milliseconds
+	RenderDuration int64    `json:"render_duration"`  // Milliseconds
+	Version        string   `json:"version,omitempty"`
+}
+
+func newConvertData(result *wkhtmltox.Result) ConvertData {
+	return ConvertData{
+		Data:           result.Data,
+		Warnings:       result.Warnings,
+		ContentType:    result.ContentType,
+		Size:           result.Size,
+		Width:          result.Width,
+		Height:         result.Height,
+		Pages:          result.Pages,
+		FetchDuration:  int64(result.FetchDuration / time.Millisecond),
+		RenderDuration: int64(result.RenderDuration / time.Millisecond),
+		Version:        result.Version,
+	}
 }
 
 type ConvertArgs struct {
@@ -53,9 +121,28 @@ type TemplateArgs struct {
 }
 
 type ConvertResponse struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Result  interface{} `json:"result"`
+	Code    int                 `json:"code"` // 0 or the http status
+	Message string              `json:"message"`
+	Error   wkhtmltox.ErrorKind `json:"error"` // Stable error code, e.g. fetch_failed
+	Result  interface{}         `json:"result"`
+}
+
+func newErrorResponse(err error) ConvertResponse {
+	kind := wkhtmltox.ErrorKindOf(err)
+
+	return ConvertResponse{
+		Code:    errorStatus[kind],
+		Message: err.Error(),
+		Error:   kind,
+	}
+}
+
+func newBadRequestResponse(message string) ConvertResponse {
+	return ConvertResponse{
+		Code:    http.StatusBadRequest,
+		Message: message,
+		Error:   wkhtmltox.ErrInvalidOptions,
+	}
 }
 
 type serverWrapper struct {
@@ -115,12 +202,31 @@ func (p *serverWrapper) Shutdown(ctx context.Context) error {
 type WKHtmlToXServer struct {
 	conf    config.Configuration
 	servers []*serverWrapper
+
+	shutdownTracing func(context.Context) error
+
+	htmlToX *wkhtmltox.WKHtmlToX
 }
 
 func New(conf config.Configuration) (srv *WKHtmlToXServer, err error) {
 
 	serviceConf := conf.GetConfig("service")
 
+	logMiddleware, err := newLoggingMiddleware(serviceConf.GetConfig("logging"), os.Stdout)
+	if err != nil {
+		return
+	}
+
+	// the logs of log.Printf are written by the structured logger too
+	slog.SetDefault(logMiddleware.logger)
+
+	tracingConf := serviceConf.GetConfig("tracing")
+
+	shutdownTracing, err := newTracerProvider(tracingConf)
+	if err != nil {
+		return
+	}
+
 	wkHtmlToXConf := conf.GetConfig("wkhtmltox")
 
 	htmlToX, err = wkhtmltox.New(wkHtmlToXConf)
@@ -175,14 +281,57 @@ func New(conf config.Configuration) (srv *WKHtmlToXServer, err error) {
 		},
 	)
 
-	n := negroni.Classic()
+	r.PathPrefix(pathPrefix).Path("/healthz").
+		Methods("GET", "HEAD").HandlerFunc(handleHealthz)
+
+	r.PathPrefix(pathPrefix).Path("/readyz").
+		Methods("GET", "HEAD").HandlerFunc(handleReadyz)
+
+	metricsEnabled := serviceConf.GetBoolean("metrics.enabled", true)
+	metricsPath := serviceConf.GetString("metrics.path", "/metrics")
+
+	if metricsEnabled {
+		r.Path(metricsPath).
+			Methods("GET").Handler(promhttp.Handler())
+	}
+
+	recovery := negroni.NewRecovery()
+	recovery.Logger = slog.NewLogLogger(logMiddleware.logger.Handler(), slog.LevelError)
+
+	n := negroni.New(recovery, logMiddleware, negroni.NewStatic(http.Dir("public")))
+
+	if tracingConf != nil && tracingConf.GetBoolean("enabled", false) {
+		n.Use(&tracingMiddleware{})
+	}
 
 	n.Use(c) // use cors
 
+	if metricsEnabled {
+		n.Use(&metricsMiddleware{path: strings.TrimRight(pathPrefix, "/") + "/convert"})
+	}
+
 	if serviceConf.GetBoolean("gzip-enabled", true) {
 		n.Use(gzip.Gzip(gzip.DefaultCompression))
 	}
 
+	if authConf := serviceConf.GetConfig("auth"); authConf != nil && authConf.GetBoolean("enabled", false) {
+		var auth *authMiddleware
+		auth, err = newAuthMiddleware(authConf, strings.TrimRight(pathPrefix, "/")+"/convert",
+			strings.TrimRight(pathPrefix, "/")+"/readyz", metricsPath)
+		if err != nil {
+			return
+		}
+		n.Use(auth)
+	}
+
+	n.Use(newClientMiddleware(serviceConf))
+
+	maxRequestSize = serviceConf.GetInt64("max-request-size", 10*1024*1024)
+
+	if limiter := newRateLimiter(serviceConf.GetConfig("rate-limit")); limiter != nil {
+		n.Use(&rateLimitMiddleware{path: strings.TrimRight(pathPrefix, "/") + "/convert", limiter: limiter})
+	}
+
 	n.UseHandler(r)
 
 	gracefulTimeout := serviceConf.GetTimeDuration("graceful.timeout", time.Second*3)
@@ -224,8 +373,10 @@ func New(conf config.Configuration) (srv *WKHtmlToXServer, err error) {
 	}
 
 	srv = &WKHtmlToXServer{
-		conf:    conf,
-		servers: servers,
+		conf:            conf,
+		servers:         servers,
+		shutdownTracing: shutdownTracing,
+		htmlToX:         htmlToX,
 	}
 
 	return
@@ -251,10 +402,25 @@ func (p *WKHtmlToXServer) Run() (err error) {
 
 	wg.Wait()
 
+	// flush the pending spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = p.shutdownTracing(ctx)
+
+	p.Close()
+
 	return
 }
 
-func writeResp(rw http.ResponseWriter, convertArgs ConvertArgs, resp ConvertResponse) {
+// Close stops the background goroutines of the converter
+func (p *WKHtmlToXServer) Close() {
+	p.htmlToX.Close()
+}
+
+func writeResp(rw http.ResponseWriter, req *http.Request, convertArgs ConvertArgs, resp ConvertResponse) {
+
+	setRequestLabels(req, convertArgs, resp)
 
 	var tmpl *template.Template
 	if len(convertArgs.Template) == 0 {
@@ -278,19 +444,41 @@ func writeResp(rw http.ResponseWriter, convertArgs ConvertArgs, resp ConvertResp
 
 	buf := bytes.NewBuffer(nil)
 
+	_, span := tracer.Start(req.Context(), "template", trace.WithAttributes(
+		attribute.String("wkhtmltox.template", tmpl.Name()),
+	))
+
 	err := tmpl.Execute(buf, args)
 
 	if err != nil {
-		log.Println(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+
+	if err != nil {
+		logging.FromContext(req.Context()).Error("execute template failure", "template", tmpl.Name(), "error", err)
 	}
 
 	if !respHelper.Holding() {
+		if resp.Code != 0 {
+			rw.WriteHeader(resp.Code)
+		}
 		rw.Write(buf.Bytes())
 	}
 }
 
 func handleHtmlToX(rw http.ResponseWriter, req *http.Request) {
 
+	req, end := startHandlerSpan(rw, req, "handleHtmlToX")
+	defer end()
+
+	req = withClient(req)
+
+	if maxRequestSize > 0 {
+		req.Body = http.MaxBytesReader(rw, req.Body, maxRequestSize)
+	}
+
 	decoder := json.NewDecoder(req.Body)
 
 	decoder.UseNumber()
@@ -300,12 +488,22 @@ func handleHtmlToX(rw http.ResponseWriter, req *http.Request) {
 	err := decoder.Decode(&args)
 
 	if err != nil {
-		writeResp(rw, args, ConvertResponse{http.StatusBadRequest, err.Error(), nil})
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeResp(rw, req, args, ConvertResponse{
+				Code:    http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("request body exceeds the limit %d", maxBytesErr.Limit),
+				Error:   errRequestTooLarge,
+			})
+			return
+		}
+
+		writeResp(rw, req, args, newBadRequestResponse(err.Error()))
 		return
 	}
 
 	if len(args.Converter) == 0 {
-		writeResp(rw, args, ConvertResponse{http.StatusBadRequest, "converter is nil", nil})
+		writeResp(rw, req, args, newBadRequestResponse("converter is nil"))
 		return
 	}
 
@@ -318,31 +516,76 @@ func handleHtmlToX(rw http.ResponseWriter, req *http.Request) {
 	} else if to == "PDF" {
 		opts = &wkhtmltox.ToPDFOptions{}
 	} else {
-		writeResp(rw, args, ConvertResponse{http.StatusBadRequest, "argument of to is illegal (image|pdf)", nil})
+		writeResp(rw, req, args, newBadRequestResponse("argument of to is illegal (image|pdf)"))
 		return
 	}
 
 	err = json.Unmarshal(args.Converter, opts)
 
 	if err != nil {
-		writeResp(rw, args, ConvertResponse{http.StatusBadRequest, err.Error(), nil})
+		writeResp(rw, req, args, newBadRequestResponse(err.Error()))
 		return
 	}
 
-	var convData []byte
+	ctx := req.Context()
 
-	convData, err = htmlToX.Convert(args.Fetcher, opts)
+	if principal := principalFromContext(ctx); principal != nil {
+		err = principal.authorize(args, opts)
+		if err != nil {
+			writeResp(rw, req, args, ConvertResponse{Code: http.StatusForbidden, Message: err.Error(), Error: errForbidden})
+			return
+		}
+
+		ctx = wkhtmltox.WithMaxOutputSize(ctx, principal.Permissions.MaxOutputSize)
+	}
+
+	var result *wkhtmltox.Result
+
+	result, err = htmlToX.ConvertResult(ctx, args.Fetcher, opts)
 
 	if err != nil {
-		writeResp(rw, args, ConvertResponse{http.StatusBadRequest, err.Error(), nil})
+		resp := newErrorResponse(err)
+		logging.FromContext(req.Context()).Warn("convert failure", "to", to, "fetcher", args.Fetcher.Name, "error", resp.Error, "message", resp.Message)
+		writeResp(rw, req, args, resp)
 		return
 	}
 
-	writeResp(rw, args, ConvertResponse{0, "", ConvertData{Data: convData}})
+	writeResp(rw, req, args, ConvertResponse{Result: newConvertData(result)})
 
 	return
 }
 
+// handleHealthz reports the process is alive
+func handleHealthz(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write([]byte(`{"status":"ok"}`))
+}
+
+// handleReadyz reports whether the service could convert, with the detail
+// of every check
+func handleReadyz(rw http.ResponseWriter, req *http.Request) {
+
+	ready, checks := htmlToX.Ready(req.Context())
+
+	data, err := json.Marshal(struct {
+		Ready  bool              `json:"ready"`
+		Checks []wkhtmltox.Check `json:"checks"`
+	}{ready, checks})
+
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if !ready {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	rw.Write(data)
+}
+
 func loadTemplates(tmplsConf config.Configuration) (err error) {
 	if tmplsConf == nil {
 		return
@@ -412,6 +655,40 @@ func (p *RespHelper) Write(data []byte) error {
 	return nil
 }
 
+// SetMetadataHeaders sets the metadata of ConvertData as X-Wkhtmltox-* headers
+func (p *RespHelper) SetMetadataHeaders(v interface{}) error {
+	data, ok := v.(ConvertData)
+	if !ok {
+		return fmt.Errorf("metadata headers could only be set by ConvertData")
+	}
+
+	header := p.rw.Header()
+
+	header.Set("Content-Type", data.ContentType)
+	header.Set("X-Wkhtmltox-Size", strconv.Itoa(data.Size))
+	header.Set("X-Wkhtmltox-Fetch-Duration", strconv.FormatInt(data.FetchDuration, 10))
+	header.Set("X-Wkhtmltox-Render-Duration", strconv.FormatInt(data.RenderDuration, 10))
+
+	if data.Pages > 0 {
+		header.Set("X-Wkhtmltox-Pages", strconv.Itoa(data.Pages))
+	}
+
+	if data.Width > 0 || data.Height > 0 {
+		header.Set("X-Wkhtmltox-Width", strconv.Itoa(data.Width))
+		header.Set("X-Wkhtmltox-Height", strconv.Itoa(data.Height))
+	}
+
+	if len(data.Version) > 0 {
+		header.Set("X-Wkhtmltox-Version", data.Version)
+	}
+
+	for _, warning := range data.Warnings {
+		header.Add("X-Wkhtmltox-Warning", warning)
+	}
+
+	return nil
+}
+
 func (p *RespHelper) WriteHeader(code interface{}) error {
 	c, err := cast.ToIntE(code)
 	if err != nil {
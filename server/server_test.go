@@ -0,0 +1,623 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/wkhtmltoxtest"
+	"github.com/golang-jwt/jwt/v5"
+
+	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/data"
+	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/http"
+)
+
+func newTestServer(t *testing.T, serviceOptions ...string) (*httptest.Server, *wkhtmltoxtest.FakeExecutor) {
+
+	srv, err := New(config.NewConfig(config.ConfigString(`
+		service {
+			path = "/v1"
+			gzip-enabled = false
+` + strings.Join(serviceOptions, "\n") + `
+
+			templates {
+				binary {
+					template = "../templates/binary.tmpl"
+				}
+			}
+		}
+
+		wkhtmltox {
+			binaries {
+				probe = false
+			}
+
+			fetchers {
+				data {
+					driver = data
+					options {}
+				}
+
+				http {
+					driver = http
+					options {}
+				}
+			}
+		}`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := wkhtmltoxtest.NewFakeExecutor([]byte("%PDF-1.4"))
+	htmlToX.SetExecutor(executor)
+
+	t.Cleanup(srv.Close)
+
+	ts := httptest.NewServer(srv.servers[0])
+
+	return ts, executor
+}
+
+func postConvert(t *testing.T, ts *httptest.Server, body string) (resp ConvertResponse, data ConvertData) {
+
+	r, err := http.Post(ts.URL+"/v1/convert", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	result := struct {
+		ConvertResponse
+		Result ConvertData `json:"result"`
+	}{}
+
+	err = json.NewDecoder(r.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result.ConvertResponse, result.Result
+}
+
+func TestServerConvert(t *testing.T) {
+
+	ts, executor := newTestServer(t)
+	defer ts.Close()
+
+	resp, data := postConvert(t, ts, `{
+		"to": "pdf",
+		"fetcher": {"name": "data", "params": {"data": "PGh0bWw+PC9odG1sPg=="}},
+		"converter": {"page_size": "A4"}
+	}`)
+
+	if resp.Code != 0 || string(data.Data) != "%PDF-1.4" {
+		t.Errorf("unexpected response %d %s, %s", resp.Code, resp.Message, data.Data)
+	}
+
+	call, _ := executor.LastCall()
+	if string(call.Stdin) != "<html></html>" {
+		t.Errorf("unexpected stdin %s", call.Stdin)
+	}
+
+	executor.Err = errors.New("render failure")
+
+	resp, _ = postConvert(t, ts, `{"to": "pdf", "converter": {"uri": "https://example.com"}}`)
+	if resp.Code != http.StatusInternalServerError || resp.Error != wkhtmltox.ErrRendererFailed || resp.Message != "render failure" {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Message)
+	}
+
+	// the message is escaped to keep the response valid json
+	executor.Err = errors.New("render \"failure\"\nof page 2")
+
+	resp, _ = postConvert(t, ts, `{"to": "pdf", "converter": {"uri": "https://example.com"}}`)
+	if resp.Code != http.StatusInternalServerError || resp.Message != "render \"failure\"\nof page 2" {
+		t.Errorf("unexpected response %d %q", resp.Code, resp.Message)
+	}
+
+	resp, _ = postConvert(t, ts, `{"to": "pdf", "fetcher": {"name": "unknown"}, "converter": {}}`)
+	if resp.Code != http.StatusBadRequest || resp.Error != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Message)
+	}
+
+	resp, _ = postConvert(t, ts, `{"to": "pdf", "fetcher": {"name": "data", "params": {}}, "converter": {}}`)
+	if resp.Code != http.StatusBadRequest || resp.Error != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Message)
+	}
+
+	resp, _ = postConvert(t, ts, `{"to": "doc", "converter": {}}`)
+	if resp.Code != http.StatusBadRequest || resp.Error != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Message)
+	}
+}
+
+func TestServerConvertHTTPFetcher(t *testing.T) {
+
+	ids := make(chan string, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/reports/1" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ids <- req.Header.Get("X-Request-ID")
+
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte("<html><body>report 1</body></html>"))
+	}))
+	defer upstream.Close()
+
+	ts, executor := newTestServer(t)
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/v1/convert", bytes.NewBufferString(`{
+		"to": "pdf",
+		"fetcher": {"name": "http", "params": {"url": "`+upstream.URL+`/reports/1"}},
+		"converter": {}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Request-ID", "req-http-1")
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", r.StatusCode)
+	}
+
+	if id := <-ids; id != "req-http-1" {
+		t.Errorf("expected request id forwarded to the upstream, got %q", id)
+	}
+
+	call, _ := executor.LastCall()
+	if string(call.Stdin) != "<html><body>report 1</body></html>" {
+		t.Errorf("unexpected stdin %s", call.Stdin)
+	}
+
+	resp, _ := postConvert(t, ts, `{"to": "pdf", "fetcher": {"name": "http", "params": {"url": "`+upstream.URL+`/reports/2"}}, "converter": {}}`)
+	if resp.Code != http.StatusBadGateway || resp.Error != wkhtmltox.ErrFetchFailed {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Message)
+	}
+}
+
+func TestServerBinary(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	r, err := http.Post(ts.URL+"/v1/convert", "application/json", bytes.NewBufferString(`{
+		"to": "pdf",
+		"converter": {"uri": "https://example.com"},
+		"template": "binary"
+	}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	if r.StatusCode != http.StatusOK || string(body) != "%PDF-1.4" {
+		t.Errorf("unexpected response %d %s", r.StatusCode, body)
+	}
+
+	if r.Header.Get("Content-Type") != "application/pdf" || r.Header.Get("X-Wkhtmltox-Size") != "8" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+}
+
+func TestServerReady(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	r, err := http.Get(ts.URL + "/v1/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	result := struct {
+		Ready  bool              `json:"ready"`
+		Checks []wkhtmltox.Check `json:"checks"`
+	}{}
+
+	err = json.NewDecoder(r.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.StatusCode != http.StatusOK || !result.Ready || len(result.Checks) == 0 {
+		t.Errorf("unexpected readiness %d %+v", r.StatusCode, result)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	postConvert(t, ts, `{"to": "pdf", "fetcher": {"name": "data", "params": {"data": "PGh0bWw+PC9odG1sPg=="}}, "converter": {}}`)
+
+	r, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	for _, metric := range []string{
+		`wkhtmltox_http_requests_total{code="200",error="none",fetcher="data",target="pdf",template="default"}`,
+		`wkhtmltox_conversions_total{error="none",fetcher="data",target="pdf"}`,
+		`wkhtmltox_render_duration_seconds_count{target="pdf"}`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("metric %s not found", metric)
+		}
+	}
+}
+
+func TestServerRequestID(t *testing.T) {
+
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	for _, id := range []string{"req-1", "", "illegal id"} {
+		req, err := http.NewRequest("GET", ts.URL+"/v1/ping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(id) > 0 {
+			req.Header.Set("X-Request-ID", id)
+		}
+
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+
+		got := r.Header.Get("X-Request-ID")
+
+		switch {
+		case id == "req-1" && got != id:
+			t.Errorf("request id %q is not returned, got %q", id, got)
+		case id != "req-1" && (len(got) == 0 || got == id):
+			t.Errorf("request id should be generated for %q, got %q", id, got)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+
+	ts, _ := newTestServer(t, `
+		auth {
+			enabled    = true
+			open-paths = ["/metrics"]
+			keys {
+				reader {
+					key = "secret"
+					fetchers = ["data"]
+					templates = ["binary"]
+				}
+
+				hashed {
+					key-sha256 = "1a06df824ed741b53c785079a6347f00eec5af82f9850775409ca69dff4068a6" # sha256 of "hashed"
+				}
+
+				tiny {
+					key = "tiny"
+					allow-uri = true
+					max-output-size = 4
+				}
+			}
+		}`)
+
+	defer ts.Close()
+
+	data := `{"to": "pdf", "fetcher": {"name": "data", "params": {"data": "PHA+aGk8L3A+"}}, "converter": {}}`
+	uri := `{"to": "pdf", "converter": {"uri": "https://example.com"}}`
+	extend := `{"to": "pdf", "fetcher": {"name": "data", "params": {"data": "PHA+aGk8L3A+"}}, "converter": {"extend": {"zoom": "2"}}}`
+
+	cases := []struct {
+		key    string
+		body   string
+		status int
+	}{
+		{"", data, http.StatusUnauthorized},
+		{"wrong", data, http.StatusUnauthorized},
+		{"secret", data, http.StatusOK},
+		{"hashed", data, http.StatusOK},
+		{"secret", uri, http.StatusForbidden},
+		{"secret", extend, http.StatusForbidden},
+		{"tiny", uri, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/convert", bytes.NewBufferString(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(c.key) > 0 {
+			req.Header.Set(HeaderAPIKey, c.key)
+		}
+
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+
+		if r.StatusCode != c.status {
+			t.Errorf("key %q of %s: expected %d, got %d %s", c.key, c.body, c.status, r.StatusCode, body)
+		}
+	}
+
+	// /readyz is protected by any valid key, /metrics is opened by open-paths
+	paths := []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{"/v1/readyz", "", http.StatusUnauthorized},
+		{"/v1/readyz", "secret", http.StatusOK},
+		{"/metrics", "", http.StatusOK},
+		{"/v1/ping", "", http.StatusOK},
+	}
+
+	for _, c := range paths {
+		req, err := http.NewRequest("GET", ts.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(c.key) > 0 {
+			req.Header.Set(HeaderAPIKey, c.key)
+		}
+
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+
+		if r.StatusCode != c.status {
+			t.Errorf("key %q of %s: expected %d, got %d", c.key, c.path, c.status, r.StatusCode)
+		}
+	}
+}
+
+func TestServerJWT(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"}}})
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+
+	err = ioutil.WriteFile(jwksFile, jwks, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, _ := newTestServer(t, `
+		auth {
+			enabled = true
+			keys {
+				reader {
+					key = "secret"
+					fetchers = ["data"]
+				}
+			}
+
+			jwt {
+				jwks-files    = ["`+jwksFile+`"]
+				issuer        = "https://idp.example.com"
+				audience      = "go-wkhtmltox"
+				clock-skew    = 5s
+				default-roles = ["browse"]
+
+				roles {
+					render {
+						fetchers = ["data"]
+					}
+
+					browse {
+						fetchers  = ["http"]
+						allow-uri = true
+					}
+				}
+			}
+		}`)
+
+	defer ts.Close()
+
+	sign := func(claims jwt.MapClaims) string {
+		base := jwt.MapClaims{
+			"iss":   "https://idp.example.com",
+			"aud":   "go-wkhtmltox",
+			"sub":   "svc-reporting",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"roles": []string{"render"},
+		}
+
+		for k, v := range claims {
+			base[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, base)
+		token.Header["kid"] = "k1"
+
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	data := `{"to": "pdf", "fetcher": {"name": "data", "params": {"data": "PHA+aGk8L3A+"}}, "converter": {}}`
+	uri := `{"to": "pdf", "converter": {"uri": "https://example.com"}}`
+
+	cases := []struct {
+		authorization string
+		apiKey        string
+		body          string
+		status        int
+	}{
+		{"Bearer " + sign(nil), "", data, http.StatusOK},
+		{"bearer " + sign(nil), "", data, http.StatusOK},
+		{"Basic " + sign(nil), "", data, http.StatusUnauthorized},
+		// the basic auth of a proxy falls back to the api key
+		{"Basic cHJveHk6cHJveHk=", "secret", data, http.StatusOK},
+		// the default roles are not granted to the token with a known role
+		{"Bearer " + sign(nil), "", uri, http.StatusForbidden},
+		{"Bearer " + sign(jwt.MapClaims{"aud": "other"}), "", data, http.StatusUnauthorized},
+		{"Bearer " + sign(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), "", data, http.StatusUnauthorized},
+		{"Bearer " + sign(jwt.MapClaims{"roles": "unknown"}), "", uri, http.StatusOK},
+		{"Bearer " + sign(jwt.MapClaims{"roles": "unknown"}), "", data, http.StatusForbidden},
+		{"Bearer invalid", "", data, http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/convert", bytes.NewBufferString(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Authorization", c.authorization)
+
+		if len(c.apiKey) > 0 {
+			req.Header.Set(HeaderAPIKey, c.apiKey)
+		}
+
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+
+		if r.StatusCode != c.status {
+			t.Errorf("case %d: expected %d, got %d %s", i, c.status, r.StatusCode, body)
+		}
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+
+	ts, _ := newTestServer(t, `
+		rate-limit {
+			enabled = true
+			rate    = 2
+			period  = 1m
+		}`)
+
+	defer ts.Close()
+
+	body := `{"to": "pdf", "converter": {"uri": "https://example.com"}}`
+
+	for i, status := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		r, err := http.Post(ts.URL+"/v1/convert", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+
+		remaining := strconv.Itoa(1 - i)
+		if i == 2 {
+			remaining = "0"
+		}
+
+		if r.StatusCode != status || r.Header.Get("RateLimit-Limit") != "2" || r.Header.Get("RateLimit-Remaining") != remaining {
+			t.Errorf("request %d: unexpected response %d %v", i, r.StatusCode, r.Header)
+		}
+
+		if status == http.StatusTooManyRequests && r.Header.Get("Retry-After") != "30" {
+			t.Errorf("unexpected retry after %s", r.Header.Get("Retry-After"))
+		}
+	}
+}
+
+func TestServerRequestTooLarge(t *testing.T) {
+
+	ts, _ := newTestServer(t, `max-request-size = 32`)
+	defer ts.Close()
+
+	resp, _ := postConvert(t, ts, `{"to": "pdf", "converter": {"uri": "https://example.com/a/long/path"}}`)
+
+	if resp.Code != http.StatusRequestEntityTooLarge || resp.Error != "request_too_large" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestServerRateLimitForwarded(t *testing.T) {
+
+	ts, _ := newTestServer(t, `
+		client-ip-header = "X-Forwarded-For"
+		trusted-proxies  = 1
+
+		rate-limit {
+			enabled = true
+			rate    = 1
+			period  = 1m
+		}`)
+
+	defer ts.Close()
+
+	body := `{"to": "pdf", "converter": {"uri": "https://example.com"}}`
+
+	// the entries sent by the client could not create new buckets
+	for i, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/convert", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("X-Forwarded-For", "192.0.2."+strconv.Itoa(i)+", 10.0.0.1")
+
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+
+		if r.StatusCode != status {
+			t.Errorf("request %d: expected %d, got %d", i, status, r.StatusCode)
+		}
+	}
+}
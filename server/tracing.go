@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/gogap/go-wkhtmltox/server")
+
+// newTracerProvider sets the global tracer provider and the w3c propagator by
+// service.tracing, the returned shutdown flushes the pending spans
+func newTracerProvider(conf config.Configuration) (shutdown func(context.Context) error, err error) {
+
+	shutdown = func(context.Context) error { return nil }
+
+	if conf == nil || !conf.GetBoolean("enabled", false) {
+		return
+	}
+
+	var exporter sdktrace.SpanExporter
+
+	switch name := conf.GetString("exporter", "otlp"); name {
+	case "otlp":
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(conf.GetString("otlp.endpoint", "localhost:4318")),
+			otlptracehttp.WithTimeout(conf.GetTimeDuration("otlp.timeout", 10*time.Second)),
+		}
+
+		if conf.GetBoolean("otlp.insecure", false) {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		headers := map[string]string{}
+		if headersConf := conf.GetConfig("otlp.headers"); headersConf != nil {
+			for _, k := range headersConf.Keys() {
+				headers[k] = headersConf.GetString(k)
+			}
+		}
+
+		if len(headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(headers))
+		}
+
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout", "file":
+		var w io.Writer = os.Stdout
+
+		if name == "file" {
+			filename := conf.GetString("file")
+			if len(filename) == 0 {
+				err = fmt.Errorf("[server]: tracing file is empty")
+				return
+			}
+
+			var f *os.File
+			f, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return
+			}
+			w = f
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		err = fmt.Errorf("[server]: tracing exporter %s not support", name)
+	}
+
+	if err != nil {
+		return
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(conf.GetString("service-name", "go-wkhtmltox")),
+	))
+
+	if err != nil {
+		return
+	}
+
+	ratio := conf.GetFloat64("sample-ratio", 1)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	shutdown = provider.Shutdown
+
+	return
+}
+
+// tracingMiddleware extracts the w3c traceparent of the incoming requests,
+// the spans of the request are the children of the caller's span
+type tracingMiddleware struct{}
+
+func (p *tracingMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	next(rw, req.WithContext(ctx))
+}
+
+// startHandlerSpan starts the server span of a handler, the returned end
+// records the response status
+func startHandlerSpan(rw http.ResponseWriter, req *http.Request, name string) (*http.Request, func()) {
+
+	ctx, span := tracer.Start(req.Context(), name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+
+	return req.WithContext(ctx), func() {
+		if nrw, ok := rw.(negroni.ResponseWriter); ok && nrw.Status() != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", nrw.Status()))
+			if nrw.Status() >= 500 {
+				span.SetStatus(codes.Error, strings.ToLower(http.StatusText(nrw.Status())))
+			}
+		}
+		span.End()
+	}
+}
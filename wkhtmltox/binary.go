@@ -0,0 +1,169 @@
+package wkhtmltox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+var (
+	helpOptionRegexp = regexp.MustCompile(`^\s+(?:-[a-zA-Z],\s+)?(--[a-z0-9-]+)`)
+)
+
+// binary is the probed wkhtmltopdf or wkhtmltoimage
+type binary struct {
+	name    string
+	path    string
+	version string
+	patched bool
+	options map[string]bool // the long options listed by --extended-help, nil while not probed
+	qthack  map[string]bool // the options marked by * in --extended-help, only available with patched qt
+}
+
+type binaries struct {
+	locker sync.RWMutex
+	items  map[string]*binary
+}
+
+// newBinaries reads the paths of the binaries, options:
+//
+//	wkhtmltopdf   = "/usr/local/bin/wkhtmltopdf"
+//	wkhtmltoimage = "/usr/local/bin/wkhtmltoimage"
+//	probe         = true
+func newBinaries(conf config.Configuration) *binaries {
+
+	bins := &binaries{items: make(map[string]*binary)}
+
+	for _, name := range []string{"wkhtmltopdf", "wkhtmltoimage"} {
+		path := name
+		if conf != nil {
+			path = conf.GetString(name, name)
+		}
+
+		bins.items[name] = &binary{name: name, path: path}
+	}
+
+	return bins
+}
+
+func (p *binaries) get(name string) *binary {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+
+	return p.items[name]
+}
+
+// Probe resolves the binaries, and probes their versions and options by the
+// executor, it is called by New unless binaries.probe is false
+func (p *WKHtmlToX) Probe(ctx context.Context) (err error) {
+
+	dir, err := p.workDir.create()
+	if err != nil {
+		return
+	}
+
+	defer p.workDir.remove(dir)
+
+	for _, name := range []string{"wkhtmltopdf", "wkhtmltoimage"} {
+
+		bin := *p.binaries.get(name)
+
+		bin.path, err = exec.LookPath(bin.path)
+		if err != nil {
+			err = fmt.Errorf("[wkhtmltox]: %s not found, please install wkhtmltox or set wkhtmltox.binaries.%s, %s", name, name, err.Error())
+			return
+		}
+
+		var version, help []byte
+
+		version, err = p.executor.Execute(ctx, Command{Name: bin.path, Args: []string{"--version"}, Dir: dir, Timeout: time.Second * 10})
+		if err != nil {
+			err = fmt.Errorf("[wkhtmltox]: probe version of %s failure, %s", bin.path, err.Error())
+			return
+		}
+
+		help, err = p.executor.Execute(ctx, Command{Name: bin.path, Args: []string{"--extended-help"}, Dir: dir, Timeout: time.Second * 10})
+		if err != nil {
+			err = fmt.Errorf("[wkhtmltox]: probe options of %s failure, %s", bin.path, err.Error())
+			return
+		}
+
+		bin.version = parseVersion(name, version)
+		bin.patched = strings.Contains(bin.version, "patched qt") && !strings.Contains(string(help), "without the wkhtmltopdf patches")
+		bin.options, bin.qthack = parseHelp(string(help))
+
+		p.binaries.locker.Lock()
+		p.binaries.items[name] = &bin
+		p.binaries.locker.Unlock()
+	}
+
+	return
+}
+
+// parseHelp reads the long options listed by --extended-help, the options
+// requiring the patched qt are marked by a * after the switch or at the end
+// of the description, e.g.
+//
+//	--header-center <text>          Centered header text *
+func parseHelp(help string) (options, qthack map[string]bool) {
+
+	options = make(map[string]bool)
+	qthack = make(map[string]bool)
+
+	var option string
+
+	for _, line := range strings.Split(help, "\n") {
+		if m := helpOptionRegexp.FindStringSubmatch(line); m != nil {
+			option = m[1]
+			options[option] = true
+		} else if len(strings.TrimSpace(line)) == 0 || !strings.HasPrefix(line, " ") {
+			// the description of the option ends by a blank line or a section
+			option = ""
+		}
+
+		if len(option) == 0 {
+			continue
+		}
+
+		for _, field := range strings.Fields(line) {
+			if field == "*" {
+				qthack[option] = true
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// check rejects the options which the binary could not honor
+func (p *binary) check(args []string) (err error) {
+
+	if p.options == nil {
+		return
+	}
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+
+		if len(p.options) > 0 && !p.options[arg] {
+			err = newError(ErrInvalidOptions, fmt.Errorf("option %s is not supported by %s %s", arg, p.name, p.version))
+			return
+		}
+
+		if !p.patched && p.qthack[arg] {
+			err = newError(ErrInvalidOptions, fmt.Errorf("option %s requires %s with patched qt, the installed is %s", arg, p.name, p.version))
+			return
+		}
+	}
+
+	return
+}
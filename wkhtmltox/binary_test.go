@@ -0,0 +1,101 @@
+package wkhtmltox_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+)
+
+const unpatchedHelp = `Name:
+  wkhtmltopdf 0.12.6
+
+Reduced Functionality:
+  This version of wkhtmltopdf has been compiled against a version of QT without
+  the wkhtmltopdf patches. Therefore some features are missing.
+
+Global Options:
+      --collate                       Collate when printing multiple copies
+  -g, --grayscale                     PDF will be generated in grayscale
+  -s, --page-size <Size>              Set paper size to: A4, Letter, etc.
+      --no-print-media-type           Do not use print media-type instead of
+                                      screen (default)
+      --header-center <text>          Centered header text *
+      --disable-smart-shrinking       Disable the intelligent shrinking
+                                      strategy used by WebKit that makes the
+                                      pixel/dpi ratio non-constant *
+`
+
+// fakeBinaries creates the binaries found by Probe, they are never executed
+// by the fake executor, the returned options point the binaries to them
+func fakeBinaries(t *testing.T) (dir string, options string) {
+
+	dir, err := ioutil.TempDir("", "go-wkhtmltox-bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, name := range []string{"wkhtmltopdf", "wkhtmltoimage"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755)
+	}
+
+	options = `binaries {
+		wkhtmltopdf   = "` + filepath.Join(dir, "wkhtmltopdf") + `"
+		wkhtmltoimage = "` + filepath.Join(dir, "wkhtmltoimage") + `"
+	}`
+
+	return
+}
+
+func TestProbe(t *testing.T) {
+
+	dir, binaries := fakeBinaries(t)
+
+	wk, executor := newWKHtmlToX(t, "%PDF-1.4", binaries)
+
+	executor.Console = []byte("wkhtmltopdf 0.12.6\n" + unpatchedHelp)
+
+	err := wk.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", PageSize: "A4", GrayScale: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if call, _ := executor.LastCall(); call.Name != filepath.Join(dir, "wkhtmltopdf") {
+		t.Errorf("unexpected binary %s", call.Name)
+	}
+
+	if result.Version == "" {
+		t.Errorf("version is not probed")
+	}
+
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", Extend: wkhtmltox.ExtendParams{"header-center": "x"}})
+	if wkhtmltox.ErrorKindOf(err) != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("expected patched qt option rejected, got %v", err)
+	}
+
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", Extend: wkhtmltox.ExtendParams{"disable-smart-shrinking": ""}})
+	if wkhtmltox.ErrorKindOf(err) != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("expected patched qt option of wrapped description rejected, got %v", err)
+	}
+
+	// the option not marked is supported without patched qt
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", Extend: wkhtmltox.ExtendParams{"no-print-media-type": ""}})
+	if err != nil {
+		t.Errorf("expected unmarked option accepted, got %v", err)
+	}
+
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", Extend: wkhtmltox.ExtendParams{"unknown-option": ""}})
+	if wkhtmltox.ErrorKindOf(err) != wkhtmltox.ErrInvalidOptions {
+		t.Errorf("expected unknown option rejected, got %v", err)
+	}
+}
@@ -2,85 +2,220 @@ package wkhtmltox
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
+	"os"
 	"os/exec"
 	"syscall"
 	"time"
 )
 
-func execCommand(timeout time.Duration, data []byte, name string, args ...string) (result []byte, err error) {
+// the max bytes of stdout and stderr kept for the log and the error message
+const maxConsoleSize = 64 * 1024
 
-	cmd := exec.Command(name, args...)
+// waitDelay bounds the waiting for the copying of the pipes after the process
+// exited or was killed, the input could block forever, e.g. a stalled upstream
+var waitDelay = 5 * time.Second
 
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-		Pgid:    0,
-	}
+func execCommand(ctx context.Context, sb *sandbox, c Command) (result []byte, err error) {
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return
-	}
+	var cmd *exec.Cmd
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return
-	}
+	if sb != nil {
+		cmd, err = sb.command(c.Dir, c.Name, c.Args...)
+		if err != nil {
+			return
+		}
+	} else {
+		cmd = exec.Command(c.Name, c.Args...)
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
+			Pgid:    0,
+		}
 	}
 
-	outBuf := bytes.NewBuffer(nil)
-	errBuf := bytes.NewBuffer(nil)
+	outBuf := &cappedWriter{limit: maxConsoleSize}
+	errBuf := newConsoleWriter(maxConsoleSize, c.Progress)
 
-	if err != nil {
-		return
+	// the output pipes are copied by exec, and Wait returns after the copying
+	// finished, or the pipes are closed after waitDelay
+	cmd.Stdout = outBuf
+	cmd.Stderr = errBuf
+	cmd.WaitDelay = waitDelay
+
+	// the input is copied by ourselves, the copying of exec is waited by Wait
+	// and could not be interrupted while it blocks on reading the input
+	var stdin, stdinWriter *os.File
+
+	if c.Input != nil {
+		stdin, stdinWriter, err = os.Pipe()
+		if err != nil {
+			return
+		}
+		cmd.Stdin = stdin
 	}
 
 	err = cmd.Start()
 
-	if err != nil {
-		return
+	if stdin != nil {
+		stdin.Close()
 	}
 
-	if len(data) > 0 {
-		_, err = io.Copy(stdin, bytes.NewBuffer(data))
-		if err != nil {
-			return
+	if err != nil {
+		if stdinWriter != nil {
+			stdinWriter.Close()
 		}
+		return
 	}
 
-	stdin.Close()
+	copied := make(chan error, 1)
 
-	go io.Copy(outBuf, stdout)
-	go io.Copy(errBuf, stderr)
+	if stdinWriter != nil {
+		go func() {
+			_, e := io.Copy(stdinWriter, c.Input)
+			stdinWriter.Close()
+			copied <- e
+		}()
+	} else {
+		copied <- nil
+	}
 
-	ch := make(chan error)
+	ch := make(chan error, 1)
 
 	go func(cmd *exec.Cmd) {
-		defer close(ch)
 		ch <- cmd.Wait()
 	}(cmd)
 
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	exceeded := watchOutput(c.Output, c.MaxOutputSize, done)
+
 	select {
 	case err = <-ch:
-	case <-time.After(timeout):
-		cmd.Process.Kill()
-		err = errors.New("execute timeout")
+		if c.OnExit != nil && cmd.ProcessState != nil {
+			c.OnExit(cmd.ProcessState)
+		}
+
+		// the input not read by the process fails the copying by broken pipe
+		if e := waitCopied(copied); err == nil && e != nil && !errors.Is(e, syscall.EPIPE) {
+			err = e
+		}
+	case <-timer.C:
+		killCommand(cmd, ch, copied)
+		err = newError(ErrTimeout, errors.New("execute timeout"))
+		return
+	case <-ctx.Done():
+		killCommand(cmd, ch, copied)
+		err = classify(ErrCanceled, ctx.Err())
+		return
+	case <-exceeded:
+		killCommand(cmd, ch, copied)
+		err = errOutputTooLarge(c.MaxOutputSize)
 		return
 	}
 
 	if err != nil {
-		errStr := errBuf.String()
-		return nil, errors.New(errStr)
+		exitCode := -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+
+		rendererErr := rendererError(exitCode, errBuf.String())
+		if len(rendererErr.Stderr) == 0 {
+			rendererErr.Err = err
+		}
+
+		return nil, rendererErr
+	}
+
+	if c.OnStderr != nil {
+		c.OnStderr(errBuf.String())
 	}
 
-	if outBuf.Len() > 0 {
-		return outBuf.Bytes(), nil
+	if outBuf.buf.Len() > 0 {
+		return outBuf.buf.Bytes(), nil
 	}
 
 	return
 }
+
+// cappedWriter keeps the first limit bytes as they are, the rest is dropped
+// without failing the writing, so the process is not blocked by a full pipe
+type cappedWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (p *cappedWriter) Write(b []byte) (n int, err error) {
+	if free := p.limit - p.buf.Len(); free > 0 {
+		if len(b) > free {
+			p.buf.Write(b[:free])
+		} else {
+			p.buf.Write(b)
+		}
+	}
+
+	return len(b), nil
+}
+
+// killCommand kills the process group, the children of wkhtmltox hold the
+// pipes as well, and waits for the copying of the pipes
+func killCommand(cmd *exec.Cmd, ch <-chan error, copied <-chan error) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	<-ch
+	waitCopied(copied)
+}
+
+// waitCopied waits for the copying of the input at most waitDelay, the
+// copying goroutine exits while the input is closed by the caller
+func waitCopied(copied <-chan error) error {
+	timer := time.NewTimer(waitDelay)
+	defer timer.Stop()
+
+	select {
+	case err := <-copied:
+		return err
+	case <-timer.C:
+		return nil
+	}
+}
+
+// outputCheckInterval is the interval of checking the size of the output
+// while it is being written
+var outputCheckInterval = 100 * time.Millisecond
+
+// watchOutput notifies while the output file grows past the limit, the
+// returned channel is never notified while the limit is 0
+func watchOutput(name string, limit int64, done <-chan struct{}) <-chan struct{} {
+
+	exceeded := make(chan struct{})
+
+	if limit <= 0 || len(name) == 0 {
+		return exceeded
+	}
+
+	go func() {
+		ticker := time.NewTicker(outputCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if fi, err := os.Stat(name); err == nil && fi.Size() > limit {
+					close(exceeded)
+					return
+				}
+			}
+		}
+	}()
+
+	return exceeded
+}
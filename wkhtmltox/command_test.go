@@ -1,12 +1,26 @@
 package wkhtmltox
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
 	"testing"
 	"time"
 )
 
 func TestExecuteCommand(t *testing.T) {
-	result, err := execCommand(time.Second*30, []byte(`http://www.qq.com`), "wkhtmltopdf", []string{"--quiet", "-", "-"}...)
+
+	if _, err := exec.LookPath("wkhtmltopdf"); err != nil {
+		t.Skip("wkhtmltopdf not installed")
+	}
+
+	result, err := execCommand(context.Background(), nil, Command{
+		Name:    "wkhtmltopdf",
+		Args:    []string{"--quiet", "-", "-"},
+		Input:   bytes.NewReader([]byte(`<html><body><p>hello</p></body></html>`)),
+		Timeout: time.Second * 30,
+	})
 
 	if err != nil {
 		t.Error(err)
@@ -18,3 +32,57 @@ func TestExecuteCommand(t *testing.T) {
 		return
 	}
 }
+
+func TestExecuteCommandCapsStdout(t *testing.T) {
+
+	result, err := execCommand(context.Background(), nil, Command{
+		Name:    "sh",
+		Args:    []string{"-c", "head -c 200000 /dev/zero"},
+		Timeout: time.Second * 10,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != maxConsoleSize {
+		t.Errorf("expected stdout capped at %d, got %d", maxConsoleSize, len(result))
+	}
+}
+
+func TestExecuteCommandKillWithBlockedInput(t *testing.T) {
+
+	old := waitDelay
+	waitDelay = 100 * time.Millisecond
+	defer func() { waitDelay = old }()
+
+	// the input never ends, the copying of stdin blocks after the kill
+	input, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := execCommand(ctx, nil, Command{
+			Name:    "sleep",
+			Args:    []string{"10"},
+			Input:   input,
+			Timeout: time.Second * 10,
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if ErrorKindOf(err) != ErrCanceled {
+			t.Errorf("expected canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("execute hangs on the input after killed")
+	}
+}
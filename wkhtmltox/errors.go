@@ -0,0 +1,108 @@
+package wkhtmltox
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+type ErrorKind string
+
+const (
+	ErrInvalidOptions      ErrorKind = "invalid_options"       // The request options are illegal
+	ErrFetchFailed         ErrorKind = "fetch_failed"          // The fetcher could not fetch the document
+	ErrTimeout             ErrorKind = "timeout"               // The converting is not finished in time
+	ErrCanceled            ErrorKind = "canceled"              // The converting is canceled by the caller
+	ErrRendererFailed      ErrorKind = "renderer_failed"       // wkhtmltox exits with non-zero or crashes
+	ErrNetworkErrorInPage  ErrorKind = "network_error_in_page" // wkhtmltox could not load the page or its resources
+	ErrInsufficientStorage ErrorKind = "insufficient_storage"  // The volume of the working dirs is nearly full
+	ErrQueueFull           ErrorKind = "queue_full"            // Too many jobs are waiting for converting
+	ErrInputTooLarge       ErrorKind = "input_too_large"       // The fetched document exceeds the size limit
+	ErrOutputTooLarge      ErrorKind = "output_too_large"      // The converted document exceeds the size limit
+	ErrTooManyPages        ErrorKind = "too_many_pages"        // The converted document exceeds the page limit
+	ErrInternal            ErrorKind = "internal"              // The other failures
+)
+
+// Error is the classified failure of converting
+type Error struct {
+	Kind     ErrorKind
+	ExitCode int    // Exit code of wkhtmltox, -1 while it is not exited
+	Stderr   string // The tail of wkhtmltox's stderr
+	Err      error
+}
+
+func (p *Error) Error() string {
+	if p.Err != nil {
+		return p.Err.Error()
+	}
+
+	if len(p.Stderr) > 0 {
+		return p.Stderr
+	}
+
+	return string(p.Kind)
+}
+
+func (p *Error) Unwrap() error {
+	return p.Err
+}
+
+// ErrorKindOf returns the kind of err, ErrInternal for the unclassified error
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return ErrInternal
+}
+
+func newError(kind ErrorKind, err error) *Error {
+	return &Error{
+		Kind:     kind,
+		ExitCode: -1,
+		Err:      err,
+	}
+}
+
+// classify keeps the kind of the classified err, and classifies the context
+// errors, the others are classified as kind
+func classify(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(ErrTimeout, err)
+	case errors.Is(err, context.Canceled):
+		return newError(ErrCanceled, err)
+	}
+
+	return newError(kind, err)
+}
+
+// rendererError classifies the failure of wkhtmltox by its exit code and stderr
+func rendererError(exitCode int, stderr string) *Error {
+
+	kind := ErrRendererFailed
+
+	if strings.Contains(stderr, "network error") || strings.Contains(stderr, "NetworkError") {
+		kind = ErrNetworkErrorInPage
+	}
+
+	return &Error{
+		Kind:     kind,
+		ExitCode: exitCode,
+		Stderr:   strings.TrimSpace(stderr),
+	}
+}
@@ -0,0 +1,30 @@
+package wkhtmltox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+
+	cases := []struct {
+		Err  error
+		Kind ErrorKind
+	}{
+		{errors.New("unknown"), ErrInternal},
+		{classify(ErrFetchFailed, errors.New("404")), ErrFetchFailed},
+		{classify(ErrFetchFailed, fmt.Errorf("fetch: %w", context.DeadlineExceeded)), ErrTimeout},
+		{classify(ErrRendererFailed, context.Canceled), ErrCanceled},
+		{classify(ErrFetchFailed, newError(ErrInvalidOptions, errors.New("bad"))), ErrInvalidOptions},
+		{rendererError(1, "Exit with code 1 due to network error: HostNotFoundError"), ErrNetworkErrorInPage},
+		{rendererError(-1, "Segmentation fault"), ErrRendererFailed},
+	}
+
+	for i, c := range cases {
+		if kind := ErrorKindOf(c.Err); kind != c.Kind {
+			t.Errorf("case %d: expected %s, got %s", i, c.Kind, kind)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package wkhtmltox
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Command is the converter command to execute
+type Command struct {
+	Name          string                 // wkhtmltopdf or wkhtmltoimage
+	Args          []string               // The args of the command, including the input and output
+	Dir           string                 // The private working dir of the converting
+	Output        string                 // The file which the result is written to
+	Input         io.Reader              // The stdin, it is nil while converting from uri or local file
+	Timeout       time.Duration          // Kill the command after timeout
+	MaxOutputSize int64                  // Kill the command while the output grows past it, 0 is unlimited
+	Progress      ProgressFunc           // Optional, called with the progress parsed from the console output
+	OnExit        func(*os.ProcessState) // Optional, called after the process exited, e.g. for its resource usage
+	OnStderr      func(stderr string)    // Optional, called with the console output after the process exited successfully, e.g. for the warnings
+}
+
+// Executor executes the converter command and returns its console output,
+// the result is read from Command.Output after executing
+type Executor interface {
+	Execute(ctx context.Context, cmd Command) (output []byte, err error)
+}
+
+// processExecutor runs the command as a child process, in the sandbox if
+// it is configured
+type processExecutor struct {
+	sandbox *sandbox
+}
+
+func (p *processExecutor) Execute(ctx context.Context, cmd Command) (output []byte, err error) {
+	return execCommand(ctx, p.sandbox, cmd)
+}
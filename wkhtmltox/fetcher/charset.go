@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	metaCharsetRegexp = regexp.MustCompile(`(?i)(<meta[^>]+charset\s*=\s*["']?)[\w-]+`)
+)
+
+// ToUTF8 transcodes the document to UTF-8 according to the content type
+// and the meta of document, the charset declared by meta is rewritten too,
+// it returns the content type with charset utf-8
+func ToUTF8(data []byte, contentType string) (ret []byte, retContentType string, err error) {
+
+	retContentType = utf8ContentType(data, contentType)
+
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+
+	// the detection only looks at the first 1024 bytes, fallback to
+	// windows-1252 is not reliable while the whole document is valid UTF-8
+	if name == "utf-8" || (!certain && name == "windows-1252" && utf8.Valid(data)) {
+		ret = data
+		return
+	}
+
+	ret, err = enc.NewDecoder().Bytes(data)
+	if err != nil {
+		err = fmt.Errorf("decode document from %s failure, %s", name, err.Error())
+		return
+	}
+
+	ret = metaCharsetRegexp.ReplaceAll(ret, []byte("${1}utf-8"))
+
+	return
+}
+
+func utf8ContentType(data []byte, contentType string) string {
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(data)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "text/html; charset=utf-8"
+	}
+
+	params["charset"] = "utf-8"
+
+	return mime.FormatMediaType(mediaType, params)
+}
@@ -0,0 +1,26 @@
+package fetcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+
+	// "中文" in GBK
+	doc := append([]byte(`<html><head><meta charset="gbk"></head><body>`), 0xd6, 0xd0, 0xce, 0xc4)
+	doc = append(doc, []byte(`</body></html>`)...)
+
+	data, contentType, err := ToUTF8(doc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+
+	if !strings.Contains(string(data), `<meta charset="utf-8">`) || !strings.Contains(string(data), "中文") {
+		t.Errorf("expected document transcoded to utf-8, got %s", data)
+	}
+}
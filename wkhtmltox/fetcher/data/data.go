@@ -47,6 +47,7 @@ func (p *DataFetcher) Fetch(fetchParams fetcher.FetchParams) (data []byte, err e
 
 	err = params.Validation()
 	if err != nil {
+		err = fetcher.InvalidParams(err)
 		return
 	}
 
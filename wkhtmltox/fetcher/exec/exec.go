@@ -0,0 +1,310 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+const (
+	FormatRaw  = "raw"  // The stdout is the document
+	FormatJSON = "json" // The stdout is a Response
+)
+
+// Response is the stdout of the executable in json format, and the stdout
+// or stderr of the executable in both format while it exits with non-zero
+type Response struct {
+	Data        []byte `json:"data"`
+	ContentType string `json:"content_type"`
+	SourceURL   string `json:"source_url"`
+	Error       string `json:"error"`
+	Code        string `json:"code"`
+}
+
+// ExecFetcher launches the executable for every fetching, the FetchParams
+// is sent to stdin in json, and the document is read from stdout
+type ExecFetcher struct {
+	command   string
+	args      []string
+	env       []string
+	dir       string
+	format    string
+	timeout   time.Duration
+	maxOutput int64
+}
+
+func init() {
+	err := fetcher.RegisterFetcher("exec", NewExecFetcher)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func NewExecFetcher(conf config.Configuration) (execFetcher fetcher.Fetcher, err error) {
+
+	if conf == nil {
+		err = fmt.Errorf("[fetcher-exec]: options is empty")
+		return
+	}
+
+	command := conf.GetString("command")
+
+	if len(command) == 0 {
+		err = fmt.Errorf("[fetcher-exec]: options of command is empty")
+		return
+	}
+
+	dir := conf.GetString("dir")
+
+	command, err = resolveCommand(command, dir)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-exec]: %s", err.Error())
+		return
+	}
+
+	format := strings.ToLower(conf.GetString("format", FormatRaw))
+
+	if format != FormatRaw && format != FormatJSON {
+		err = fmt.Errorf("[fetcher-exec]: format %s not support", format)
+		return
+	}
+
+	execFetcher = &ExecFetcher{
+		command:   command,
+		args:      conf.GetStringList("args"),
+		env:       conf.GetStringList("env"),
+		dir:       dir,
+		format:    format,
+		timeout:   conf.GetTimeDuration("timeout", time.Second*30),
+		maxOutput: conf.GetInt64("max-output", 50*1024*1024),
+	}
+
+	return
+}
+
+func (p *ExecFetcher) Fetch(fetchParams fetcher.FetchParams) (data []byte, err error) {
+
+	doc, err := p.FetchStream(context.Background(), fetchParams)
+	if err != nil {
+		return
+	}
+
+	data, err = doc.ReadAll()
+
+	return
+}
+
+func (p *ExecFetcher) FetchStream(ctx context.Context, fetchParams fetcher.FetchParams) (doc *fetcher.Document, err error) {
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	params := []byte(fetchParams)
+	if len(params) == 0 {
+		params = []byte("null")
+	}
+
+	cmd := exec.Command(p.command, p.args...)
+
+	cmd.Dir = p.dir
+	cmd.Env = append(os.Environ(), p.env...)
+	cmd.Stdin = bytes.NewReader(params)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+
+	stdout := &limitedBuffer{limit: p.maxOutput, full: make(chan struct{})}
+	stderr := &limitedBuffer{limit: 64 * 1024, truncate: true}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Start()
+	if err != nil {
+		err = fmt.Errorf("[fetcher-exec]: start %s failure, %s", p.command, err.Error())
+		return
+	}
+
+	ch := make(chan error, 1)
+
+	go func() {
+		ch <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		{
+			// kill the process group, the executable may have children
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-ch
+
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("[fetcher-exec]: execute %s timeout", p.command)
+			} else {
+				err = ctx.Err()
+			}
+			return
+		}
+	case <-stdout.full:
+		{
+			// the rest of the output is dropped, stop the executable at once
+			// rather than waiting for the timeout
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-ch
+		}
+	}
+
+	if stdout.exceeded {
+		err = fmt.Errorf("[fetcher-exec]: output of %s exceeds limit of %d bytes", p.command, p.maxOutput)
+		return
+	}
+
+	if err != nil {
+		err = p.failure(err, stdout.Bytes(), stderr.Bytes())
+		return
+	}
+
+	if p.format == FormatRaw {
+		doc = fetcher.NewDocument(stdout.Bytes(), fetcher.Metadata{})
+		return
+	}
+
+	resp := Response{}
+
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-exec]: parse output of %s failure, %s", p.command, err.Error())
+		return
+	}
+
+	if len(resp.Error) > 0 {
+		err = resp.toError()
+		return
+	}
+
+	doc = fetcher.NewDocument(resp.Data, fetcher.Metadata{
+		ContentType: resp.ContentType,
+		SourceURL:   resp.SourceURL,
+	})
+
+	return
+}
+
+// resolveCommand returns the absolute path of the command, the relative path
+// is relative to dir as the executable is started in it, and the bare name is
+// found in PATH
+func resolveCommand(command, dir string) (path string, err error) {
+
+	if strings.ContainsRune(command, filepath.Separator) && !filepath.IsAbs(command) && len(dir) > 0 {
+		command = filepath.Join(dir, command)
+	}
+
+	path, err = exec.LookPath(command)
+	if err != nil {
+		return
+	}
+
+	// the path is kept while the working dir of the server changes
+	path, err = filepath.Abs(path)
+
+	return
+}
+
+// Health checks the executable is still available
+func (p *ExecFetcher) Health(ctx context.Context) (err error) {
+	_, err = exec.LookPath(p.command)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-exec]: %s", err.Error())
+	}
+	return
+}
+
+// failure parses the structured error from stdout or stderr, or returns the
+// stderr as error message
+func (p *ExecFetcher) failure(exitErr error, stdout, stderr []byte) error {
+
+	for _, out := range [][]byte{stdout, stderr} {
+		resp := Response{}
+		if json.Unmarshal(bytes.TrimSpace(out), &resp) == nil && len(resp.Error) > 0 {
+			return resp.toError()
+		}
+	}
+
+	msg := strings.TrimSpace(string(stderr))
+	if len(msg) == 0 {
+		msg = exitErr.Error()
+	}
+
+	return fmt.Errorf("[fetcher-exec]: execute %s failure, %s", p.command, msg)
+}
+
+func (p *Response) toError() error {
+	if len(p.Code) > 0 {
+		return fmt.Errorf("[fetcher-exec]: %s: %s", p.Code, p.Error)
+	}
+
+	return fmt.Errorf("[fetcher-exec]: %s", p.Error)
+}
+
+// limitedBuffer stops buffering after limit, truncate keeps the last bytes
+// instead of the first bytes, full is closed while the limit is exceeded,
+// the buffer is not embedded, or its ReadFrom is used by exec bypassing Write
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	truncate bool
+	exceeded bool
+	full     chan struct{}
+}
+
+func (p *limitedBuffer) Write(b []byte) (n int, err error) {
+	n = len(b)
+
+	if p.limit <= 0 {
+		return p.buf.Write(b)
+	}
+
+	if int64(p.buf.Len()+len(b)) <= p.limit {
+		return p.buf.Write(b)
+	}
+
+	if !p.exceeded && p.full != nil {
+		close(p.full)
+	}
+
+	p.exceeded = true
+
+	if !p.truncate {
+		return
+	}
+
+	p.buf.Write(b)
+
+	tail := p.buf.Bytes()[int64(p.buf.Len())-p.limit:]
+	tail = append([]byte(nil), tail...)
+
+	p.buf.Reset()
+	p.buf.Write(tail)
+
+	return
+}
+
+func (p *limitedBuffer) Bytes() []byte {
+	return p.buf.Bytes()
+}
@@ -0,0 +1,109 @@
+package exec
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+func newStubFetcher(t *testing.T, timeout string) fetcher.Fetcher {
+	f, err := NewExecFetcher(config.NewConfig(config.ConfigString(`
+		command = "testdata/stub.sh"
+		timeout = ` + timeout)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestExecFetcher(t *testing.T) {
+
+	f := newStubFetcher(t, "5s")
+
+	data, err := f.Fetch(fetcher.FetchParams(`{"id":"report-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(data)) != `<html><body>{"id":"report-1"}</body></html>` {
+		t.Errorf("unexpected document %s", data)
+	}
+
+	_, err = f.Fetch(fetcher.FetchParams(`{"id":"not_found"}`))
+	if err == nil || err.Error() != "[fetcher-exec]: not_found: document not found" {
+		t.Errorf("expected structured error, got %v", err)
+	}
+
+	_, err = f.Fetch(fetcher.FetchParams(`{"id":"crash"}`))
+	if err == nil || !strings.Contains(err.Error(), "segmentation fault") {
+		t.Errorf("expected stderr in error, got %v", err)
+	}
+}
+
+func TestExecFetcherTimeout(t *testing.T) {
+
+	f := newStubFetcher(t, "100ms")
+
+	_, err := f.Fetch(fetcher.FetchParams(`{"id":"slow"}`))
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestExecFetcherMaxOutput(t *testing.T) {
+
+	f, err := NewExecFetcher(config.NewConfig(config.ConfigString(`
+		command    = "testdata/stub.sh"
+		timeout    = 10s
+		max-output = 1024`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	// the executable floods the stdout until it is killed
+	_, err = f.Fetch(fetcher.FetchParams(`{"id":"flood"}`))
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("expected output exceeds limit, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected executable killed after exceeding the limit, took %s", elapsed)
+	}
+}
+
+func TestExecFetcherRelativeCommand(t *testing.T) {
+
+	// the relative command is relative to dir rather than the working dir of
+	// the server
+	f, err := NewExecFetcher(config.NewConfig(config.ConfigString(`
+		command = "./stub.sh"
+		dir     = "testdata"`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command := f.(*ExecFetcher).command; !filepath.IsAbs(command) || filepath.Base(command) != "stub.sh" {
+		t.Errorf("expected absolute command, got %s", command)
+	}
+
+	err = f.(*ExecFetcher).Health(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = f.Fetch(fetcher.FetchParams(`{"id":"report-1"}`))
+	if err != nil {
+		t.Error(err)
+	}
+}
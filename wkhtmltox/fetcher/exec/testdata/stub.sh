@@ -0,0 +1,23 @@
+#!/bin/sh
+# stub fetcher, it reads the FetchParams json from stdin and writes the document to stdout
+
+params=$(cat)
+
+case "$params" in
+	*'"not_found"'*)
+		echo '{"error":"document not found","code":"not_found"}'
+		exit 1
+		;;
+	*'"crash"'*)
+		echo "segmentation fault" >&2
+		exit 2
+		;;
+	*'"slow"'*)
+		sleep 10
+		;;
+	*'"flood"'*)
+		yes '<p>flood</p>'
+		;;
+esac
+
+echo "<html><body>$params</body></html>"
@@ -13,6 +13,28 @@ type Fetcher interface {
 
 type FetchParams []byte
 
+// ParamsError is the failure caused by the illegal FetchParams, it is the
+// fault of the caller rather than the source of the document
+type ParamsError struct {
+	Err error
+}
+
+func (p *ParamsError) Error() string {
+	return p.Err.Error()
+}
+
+func (p *ParamsError) Unwrap() error {
+	return p.Err
+}
+
+// InvalidParams marks err as a ParamsError, nil is returned for nil
+func InvalidParams(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ParamsError{Err: err}
+}
+
 func (p *FetchParams) Unmarshal(v interface{}) (err error) {
 	if p == nil {
 		return
@@ -21,7 +43,7 @@ func (p *FetchParams) Unmarshal(v interface{}) (err error) {
 	err = json.Unmarshal([]byte(*p), v)
 
 	if err != nil {
-		err = fmt.Errorf("parse param failure, error is %s", err.Error())
+		err = InvalidParams(fmt.Errorf("parse param failure, error is %s", err.Error()))
 		return
 	}
 
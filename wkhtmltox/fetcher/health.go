@@ -0,0 +1,23 @@
+package fetcher
+
+import (
+	"context"
+)
+
+// HealthChecker is optionally implemented by the fetcher drivers, it is
+// called by the readiness checking
+type HealthChecker interface {
+	Health(ctx context.Context) error
+}
+
+// AsHealthChecker returns the fetcher as HealthChecker, the fetcher adapted
+// by ToStream is unwrapped
+func AsHealthChecker(f interface{}) (hc HealthChecker, ok bool) {
+	if adapter, isAdapter := f.(*streamAdapter); isAdapter {
+		f = adapter.fetcher
+	}
+
+	hc, ok = f.(HealthChecker)
+
+	return
+}
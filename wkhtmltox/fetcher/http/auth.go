@@ -0,0 +1,353 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+type authenticator interface {
+	authenticate(req *http.Request, body []byte) error
+}
+
+// invalidator is implemented by authenticators which cache credentials,
+// it will be called while the server responds 401, and the request is sent
+// once more with a fresh credential
+type invalidator interface {
+	invalidate()
+}
+
+// authProfile is the authenticator of a profile, and the hosts which its
+// credentials could be sent to
+type authProfile struct {
+	auth         authenticator
+	allowedHosts map[string]bool
+}
+
+// allowed reports whether the credentials could be sent to the url, the host
+// is matched with or without the port
+func (p *authProfile) allowed(u *url.URL) bool {
+	return p.allowedHosts[strings.ToLower(u.Host)] || p.allowedHosts[strings.ToLower(u.Hostname())]
+}
+
+func newAuthenticators(conf config.Configuration, client *http.Client) (auths map[string]*authProfile, err error) {
+
+	auths = make(map[string]*authProfile)
+
+	if conf == nil {
+		return
+	}
+
+	for _, name := range conf.Keys() {
+
+		profileConf := conf.GetConfig(name)
+
+		if profileConf == nil {
+			err = fmt.Errorf("[fetcher-http]: auth profile of %s is empty", name)
+			return
+		}
+
+		authType := strings.ToLower(profileConf.GetString("type"))
+
+		var auth authenticator
+
+		switch authType {
+		case "basic":
+			auth = &basicAuth{
+				username: profileConf.GetString("username"),
+				password: profileConf.GetString("password"),
+			}
+		case "bearer":
+			auth = &bearerAuth{
+				token: profileConf.GetString("token"),
+			}
+		case "oauth2":
+			auth, err = newOAuth2Auth(name, profileConf, client)
+		case "hmac":
+			auth, err = newHMACAuth(name, profileConf)
+		default:
+			err = fmt.Errorf("[fetcher-http]: auth type %s of profile %s not support", authType, name)
+		}
+
+		if err != nil {
+			return
+		}
+
+		// the url comes from the request, the credentials must not be sent
+		// to the hosts of the callers
+		hosts := profileConf.GetStringList("allowed-hosts")
+		if len(hosts) == 0 {
+			err = fmt.Errorf("[fetcher-http]: allowed-hosts of auth profile %s is empty", name)
+			return
+		}
+
+		profile := &authProfile{auth: auth, allowedHosts: make(map[string]bool)}
+		for _, host := range hosts {
+			profile.allowedHosts[strings.ToLower(host)] = true
+		}
+
+		auths[name] = profile
+	}
+
+	return
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (p *basicAuth) authenticate(req *http.Request, body []byte) error {
+	req.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+type bearerAuth struct {
+	token string
+}
+
+func (p *bearerAuth) authenticate(req *http.Request, body []byte) error {
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	return nil
+}
+
+// now is replaced by tests
+var now = time.Now
+
+// oauth2Auth implements the OAuth2 client credentials grant,
+// the access token is cached until it is nearly expired, or for defaultTTL
+// while the token response has no expires_in
+type oauth2Auth struct {
+	name         string
+	client       *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	params       map[string]string
+	expiryDelta  time.Duration
+	defaultTTL   time.Duration
+
+	locker  sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newOAuth2Auth(name string, conf config.Configuration, client *http.Client) (auth *oauth2Auth, err error) {
+
+	tokenURL := conf.GetString("token-url")
+
+	if len(tokenURL) == 0 {
+		err = fmt.Errorf("[fetcher-http]: token-url of auth profile %s is empty", name)
+		return
+	}
+
+	params := make(map[string]string)
+
+	if paramsConf := conf.GetConfig("params"); paramsConf != nil {
+		for _, k := range paramsConf.Keys() {
+			params[k] = paramsConf.GetString(k)
+		}
+	}
+
+	auth = &oauth2Auth{
+		name:         name,
+		client:       client,
+		tokenURL:     tokenURL,
+		clientID:     conf.GetString("client-id"),
+		clientSecret: conf.GetString("client-secret"),
+		scopes:       conf.GetStringList("scopes"),
+		params:       params,
+		expiryDelta:  conf.GetTimeDuration("expiry-delta", time.Second*30),
+		defaultTTL:   conf.GetTimeDuration("default-ttl", time.Minute*5),
+	}
+
+	return
+}
+
+func (p *oauth2Auth) authenticate(req *http.Request, body []byte) (err error) {
+
+	token, err := p.accessToken(req.Context())
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return
+}
+
+func (p *oauth2Auth) invalidate() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.token = ""
+}
+
+func (p *oauth2Auth) accessToken(ctx context.Context) (token string, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if len(p.token) > 0 && now().Before(p.expires) {
+		token = p.token
+		return
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	if len(p.scopes) > 0 {
+		form.Set("scope", strings.Join(p.scopes, " "))
+	}
+
+	for k, v := range p.params {
+		form.Set(k, v)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-http]: request token of auth profile %s failure, %s", p.name, err.Error())
+		return
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("[fetcher-http]: request token of auth profile %s failure, status code is %d", p.name, resp.StatusCode)
+		return
+	}
+
+	tokenResp := struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}{}
+
+	err = json.Unmarshal(data, &tokenResp)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-http]: parse token of auth profile %s failure, %s", p.name, err.Error())
+		return
+	}
+
+	if len(tokenResp.AccessToken) == 0 {
+		err = fmt.Errorf("[fetcher-http]: token response of auth profile %s has no access_token", p.name)
+		return
+	}
+
+	p.token = tokenResp.AccessToken
+	p.expires = now().Add(p.defaultTTL)
+
+	if expiresIn, e := tokenResp.ExpiresIn.Int64(); e == nil && expiresIn > 0 {
+		p.expires = now().Add(time.Duration(expiresIn)*time.Second - p.expiryDelta)
+	}
+
+	token = p.token
+
+	return
+}
+
+// hmacAuth signs the request by shared secret, the string to sign is
+//
+//	METHOD\nPATH?QUERY\nTIMESTAMP\nHEX(SHA256(BODY))
+//
+// the timestamp is in unix seconds and sent by timestamp-header
+type hmacAuth struct {
+	keyID           string
+	secret          []byte
+	newHash         func() hash.Hash
+	algorithm       string
+	header          string
+	timestampHeader string
+}
+
+func newHMACAuth(name string, conf config.Configuration) (auth *hmacAuth, err error) {
+
+	secret := conf.GetString("secret")
+
+	if len(secret) == 0 {
+		err = fmt.Errorf("[fetcher-http]: secret of auth profile %s is empty", name)
+		return
+	}
+
+	algorithm := strings.ToLower(conf.GetString("algorithm", "sha256"))
+
+	var newHash func() hash.Hash
+
+	switch algorithm {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		err = fmt.Errorf("[fetcher-http]: algorithm %s of auth profile %s not support", algorithm, name)
+		return
+	}
+
+	auth = &hmacAuth{
+		keyID:           conf.GetString("key-id"),
+		secret:          []byte(secret),
+		newHash:         newHash,
+		algorithm:       strings.ToUpper("hmac-" + algorithm),
+		header:          conf.GetString("header", "Authorization"),
+		timestampHeader: conf.GetString("timestamp-header", "X-Timestamp"),
+	}
+
+	return
+}
+
+func (p *hmacAuth) authenticate(req *http.Request, body []byte) error {
+
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	bodyHash := sha256.Sum256(body)
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.URL.RequestURI(),
+		timestamp,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(p.newHash, p.secret)
+	mac.Write([]byte(stringToSign))
+
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set(p.timestampHeader, timestamp)
+	req.Header.Set(p.header, fmt.Sprintf("%s KeyId=%s,Signature=%s", p.algorithm, p.keyID, signature))
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+func TestFetchAuthProfiles(t *testing.T) {
+
+	var tokenHits int32
+
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id, secret, _ := req.BasicAuth()
+		if id != "client" || secret != "secret" || req.FormValue("grant_type") != "client_credentials" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		atomic.AddInt32(&tokenHits, 1)
+		rw.Write([]byte(`{"access_token":"token-1","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if auth != "Bearer token-1" &&
+			!(strings.HasPrefix(auth, "HMAC-SHA256 KeyId=reports,Signature=") && len(req.Header.Get("X-Timestamp")) > 0) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	conf := config.NewConfig(config.ConfigString(fmt.Sprintf(`
+		auth {
+			idp {
+				type          = oauth2
+				token-url     = "%s"
+				client-id     = client
+				client-secret = secret
+				allowed-hosts = ["127.0.0.1"]
+			}
+
+			signer {
+				type   = hmac
+				key-id = reports
+				secret = "shared-secret"
+				allowed-hosts = ["127.0.0.1"]
+			}
+		}`, tokenSrv.URL)))
+
+	f, err := NewHttpFetcher(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, profile := range []string{"idp", "idp", "signer"} {
+		params, _ := json.Marshal(Params{URL: srv.URL, Auth: profile})
+
+		_, err = f.Fetch(fetcher.FetchParams(params))
+		if err != nil {
+			t.Fatalf("fetch with profile %s failure: %s", profile, err)
+		}
+	}
+
+	if tokenHits != 1 {
+		t.Errorf("expected token to be cached, requested %d times", tokenHits)
+	}
+
+	params, _ := json.Marshal(Params{URL: srv.URL, Auth: "unknown"})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+	if err == nil {
+		t.Error("expected unknown profile to be rejected")
+	}
+}
+
+func TestFetchOAuth2Reauthorize(t *testing.T) {
+
+	var tokenHits int32
+
+	// the token without expires_in is cached for default-ttl
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		hits := atomic.AddInt32(&tokenHits, 1)
+		fmt.Fprintf(rw, `{"access_token":"token-%d","token_type":"bearer"}`, hits)
+	}))
+	defer tokenSrv.Close()
+
+	// token-1 is revoked before it expires
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	conf := config.NewConfig(config.ConfigString(fmt.Sprintf(`
+		auth {
+			idp {
+				type          = oauth2
+				token-url     = "%s"
+				allowed-hosts = ["127.0.0.1"]
+			}
+		}`, tokenSrv.URL)))
+
+	f, err := NewHttpFetcher(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ := json.Marshal(Params{URL: srv.URL, Auth: "idp"})
+
+	for i := 0; i < 2; i++ {
+		data, err := f.Fetch(fetcher.FetchParams(params))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "ok" {
+			t.Errorf("unexpected data %q", data)
+		}
+	}
+
+	if tokenHits != 2 {
+		t.Errorf("expected token requested once more after 401 and cached then, requested %d times", tokenHits)
+	}
+}
+
+func TestFetchAuthAllowedHosts(t *testing.T) {
+
+	var leaked int32
+
+	// the host of the caller, e.g. localhost is not 127.0.0.1 for the profile
+	evil := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.Header.Get("Authorization")) > 0 {
+			atomic.AddInt32(&leaked, 1)
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer evil.Close()
+
+	// the allowed upstream redirects to the evil host
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, strings.Replace(evil.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	f, err := NewHttpFetcher(config.NewConfig(config.ConfigString(`
+		auth {
+			api {
+				type          = bearer
+				token         = "secret-token"
+				allowed-hosts = ["127.0.0.1"]
+			}
+		}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ := json.Marshal(Params{URL: strings.Replace(evil.URL, "127.0.0.1", "localhost", 1), Auth: "api"})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+
+	var paramsErr *fetcher.ParamsError
+	if !errors.As(err, &paramsErr) {
+		t.Errorf("expected url of other host rejected as illegal params, got %v", err)
+	}
+
+	params, _ = json.Marshal(Params{URL: srv.URL, Auth: "api"})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+	if err == nil {
+		t.Error("expected redirect to other host refused")
+	}
+
+	if leaked != 0 {
+		t.Errorf("credentials are sent to the host not allowed %d times", leaked)
+	}
+
+	_, err = NewHttpFetcher(config.NewConfig(config.ConfigString(`
+		auth {
+			api {
+				type  = bearer
+				token = "secret-token"
+			}
+		}`)))
+	if err == nil {
+		t.Error("expected profile without allowed-hosts refused")
+	}
+}
+
+func TestHMACSignature(t *testing.T) {
+
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	defer func() { now = time.Now }()
+
+	auth, err := newHMACAuth("signer", config.NewConfig(config.ConfigString(`
+		key-id = reports
+		secret = "shared-secret"`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/report?id=1", nil)
+
+	err = auth.authenticate(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// printf 'GET\n/report?id=1\n1700000000\n<sha256 of empty body>' | openssl dgst -sha256 -hmac shared-secret
+	expected := "HMAC-SHA256 KeyId=reports,Signature=c008d20128ff369e40d22f92a78075fc512e0324508ce14dae58e3e5c941118c"
+
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	if got := req.Header.Get("X-Timestamp"); got != "1700000000" {
+		t.Errorf("unexpected timestamp %s", got)
+	}
+}
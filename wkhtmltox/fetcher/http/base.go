@@ -0,0 +1,227 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	BaseNone       = "none"       // Keep the document as it is
+	BaseInject     = "inject"     // Inject <base href> of the source url while the document has none
+	BaseAbsolutize = "absolutize" // Rewrite relative urls of the elements to absolute urls
+)
+
+var (
+	urlAttrs = []string{"href", "src", "action", "poster", "background", "data"}
+)
+
+func validBaseMode(mode string) bool {
+	switch mode {
+	case BaseNone, BaseInject, BaseAbsolutize:
+		return true
+	}
+	return false
+}
+
+func isHTML(data []byte, contentType string) bool {
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(data)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// resolveBase makes the relative urls in the document work while it is piped
+// to wkhtmltox by stdin, which has no location to resolve them
+func resolveBase(data []byte, contentType string, mode string, baseURL string) (ret []byte, err error) {
+
+	if mode == BaseNone || len(baseURL) == 0 || !isHTML(data, contentType) {
+		ret = data
+		return
+	}
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-http]: base url %s is illegal, %s", baseURL, err.Error())
+		return
+	}
+
+	data, _, err = fetcher.ToUTF8(data, contentType)
+	if err != nil {
+		return
+	}
+
+	if mode == BaseInject {
+		ret = injectBase(data, base)
+		return
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	// a relative <base href> in document is relative to the source url
+	if existing, exist := doc.Find("head base[href]").First().Attr("href"); exist {
+		if ref, e := url.Parse(strings.TrimSpace(existing)); e == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	doc.Find("head base").Remove()
+
+	for _, attr := range urlAttrs {
+		doc.Find("[" + attr + "]").Each(func(_ int, s *goquery.Selection) {
+			v, _ := s.Attr(attr)
+			s.SetAttr(attr, absoluteURL(base, v))
+		})
+	}
+
+	doc.Find("[srcset]").Each(func(_ int, s *goquery.Selection) {
+		v, _ := s.Attr("srcset")
+		s.SetAttr("srcset", absoluteSrcset(base, v))
+	})
+
+	ret, err = renderDocument(doc)
+
+	return
+}
+
+// injectBase inserts <base href> after the <head> (or <html>, or the
+// doctype) tag, the document is kept byte for byte otherwise, an existing
+// relative <base href> is resolved against the source url in place
+func injectBase(data []byte, base *url.URL) []byte {
+
+	z := xhtml.NewTokenizer(bytes.NewReader(data))
+
+	offset, insertAt := 0, 0
+
+scan:
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+
+		raw := len(z.Raw())
+		offset += raw
+
+		if tt == xhtml.DoctypeToken {
+			insertAt = offset
+			continue
+		}
+
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+
+		switch string(name) {
+		case "html":
+			insertAt = offset
+		case "head":
+			insertAt = offset
+		case "base":
+			var attrs []xhtml.Attribute
+			href := -1
+
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "href" && href < 0 {
+					href = len(attrs)
+				}
+				attrs = append(attrs, xhtml.Attribute{Key: string(key), Val: string(val)})
+			}
+
+			if href < 0 {
+				continue
+			}
+
+			ref, err := url.Parse(strings.TrimSpace(attrs[href].Val))
+			if err != nil || ref.IsAbs() {
+				return data
+			}
+
+			attrs[href].Val = base.ResolveReference(ref).String()
+
+			return replaceTag(data, offset-raw, offset, "base", attrs)
+		case "meta", "title", "link", "style", "script", "noscript", "template":
+		default:
+			// the base is only allowed in head
+			break scan
+		}
+	}
+
+	tag := `<base href="` + html.EscapeString(base.String()) + `"/>`
+
+	ret := make([]byte, 0, len(data)+len(tag))
+	ret = append(ret, data[:insertAt]...)
+	ret = append(ret, tag...)
+	ret = append(ret, data[insertAt:]...)
+
+	return ret
+}
+
+// replaceTag writes the tag between start and end again with the attrs
+func replaceTag(data []byte, start, end int, name string, attrs []xhtml.Attribute) []byte {
+
+	tag := "<" + name
+	for _, attr := range attrs {
+		tag += " " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`
+	}
+	tag += "/>"
+
+	ret := make([]byte, 0, len(data)+len(tag))
+	ret = append(ret, data[:start]...)
+	ret = append(ret, tag...)
+	ret = append(ret, data[end:]...)
+
+	return ret
+}
+
+func absoluteURL(base *url.URL, ref string) string {
+	trimed := strings.TrimSpace(ref)
+
+	if len(trimed) == 0 || strings.HasPrefix(trimed, "#") {
+		return ref
+	}
+
+	u, err := url.Parse(trimed)
+	if err != nil || u.IsAbs() {
+		return ref
+	}
+
+	return base.ResolveReference(u).String()
+}
+
+func absoluteSrcset(base *url.URL, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+
+		fields[0] = absoluteURL(base, fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+
+	return strings.Join(candidates, ", ")
+}
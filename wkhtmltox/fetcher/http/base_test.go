@@ -0,0 +1,81 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveBase(t *testing.T) {
+
+	doc := []byte(`<html><head><link rel="stylesheet" href="css/a.css"/></head><body><img src="/img/a.png" srcset="a.png 1x, b.png 2x"/><a href="#top">top</a></body></html>`)
+
+	data, err := resolveBase(doc, "text/html", BaseInject, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != strings.Replace(string(doc), "<head>", `<head><base href="https://example.com/reports/1"/>`, 1) {
+		t.Errorf("expected base injected, got %s", data)
+	}
+
+	// the relative base is resolved against the source url in place
+	withBase := []byte(`<html><HEAD><Base target="_self" href="/reports/"></HEAD><body><p>a<br></body></html>`)
+
+	data, err = resolveBase(withBase, "text/html", BaseInject, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := `<html><HEAD><base target="_self" href="https://example.com/reports/"/></HEAD><body><p>a<br></body></html>`; string(data) != expected {
+		t.Errorf("expected relative base resolved, got %s", data)
+	}
+
+	// the document is kept as it is while it has an absolute base already
+	withBase = []byte(`<html><head><base href="https://cdn.example.com/"></head><body></body></html>`)
+
+	data, err = resolveBase(withBase, "text/html", BaseInject, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != string(withBase) {
+		t.Errorf("expected document with base unchanged, got %s", data)
+	}
+
+	// the base is inserted after the doctype without <html> and <head>
+	fragment := []byte("<!DOCTYPE html>\n<p>a</p>")
+
+	data, err = resolveBase(fragment, "text/html", BaseInject, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "<!DOCTYPE html><base href=\"https://example.com/reports/1\"/>\n<p>a</p>"; string(data) != expected {
+		t.Errorf("expected base after doctype, got %s", data)
+	}
+
+	data, err = resolveBase(doc, "text/html", BaseAbsolutize, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`href="https://example.com/reports/css/a.css"`,
+		`src="https://example.com/img/a.png"`,
+		`srcset="https://example.com/reports/a.png 1x, https://example.com/reports/b.png 2x"`,
+		`href="#top"`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %s in %s", expected, data)
+		}
+	}
+
+	data, err = resolveBase(doc, "application/pdf", BaseInject, "https://example.com/reports/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != string(doc) {
+		t.Errorf("expected non html document unchanged, got %s", data)
+	}
+}
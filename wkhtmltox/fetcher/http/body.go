@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var (
+	quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+)
+
+// MultipartBody is the multipart/form-data request body
+type MultipartBody struct {
+	Fields map[string]string `json:"fields"`
+	Files  []MultipartFile   `json:"files"`
+}
+
+type MultipartFile struct {
+	Field       string `json:"field"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+type request struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+	auth   authenticator
+
+	authProfile *authProfile // the redirects are limited to its hosts as well
+}
+
+type response struct {
+	data        []byte
+	contentType string
+	url         string // the final url after redirects
+	statusCode  int
+	body        io.ReadCloser // the unread body while streaming, data is nil then
+	size        int64         // the content length, -1 while unknown
+}
+
+func (p *Params) bodyCount() int {
+	count := 0
+
+	if len(p.Data) > 0 {
+		count++
+	}
+
+	if len(p.JSON) > 0 {
+		count++
+	}
+
+	if len(p.Form) > 0 {
+		count++
+	}
+
+	if p.Multipart != nil {
+		count++
+	}
+
+	return count
+}
+
+func (p *Params) toRequest() (req request, err error) {
+
+	reqURL, err := url.Parse(p.URL)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-http]: params of url is illegal, %s", err.Error())
+		return
+	}
+
+	if len(p.Query) > 0 {
+		query := reqURL.Query()
+		for k, v := range p.Query {
+			query.Set(k, v)
+		}
+		reqURL.RawQuery = query.Encode()
+	}
+
+	req = request{
+		method: p.Method,
+		url:    reqURL.String(),
+		header: make(http.Header),
+	}
+
+	for k, v := range p.Headers {
+		req.header.Set(k, v)
+	}
+
+	contentType := ""
+
+	switch {
+	case len(p.JSON) > 0:
+		{
+			if !json.Valid(p.JSON) {
+				err = fmt.Errorf("[fetcher-http]: params of json is not valid json")
+				return
+			}
+			req.body = []byte(p.JSON)
+			contentType = "application/json; charset=utf-8"
+		}
+	case len(p.Form) > 0:
+		{
+			form := url.Values{}
+			for k, v := range p.Form {
+				form.Set(k, v)
+			}
+			req.body = []byte(form.Encode())
+			contentType = "application/x-www-form-urlencoded"
+		}
+	case p.Multipart != nil:
+		{
+			req.body, contentType, err = p.Multipart.encode()
+			if err != nil {
+				return
+			}
+		}
+	default:
+		req.body = p.Data
+	}
+
+	if len(contentType) > 0 && len(req.header.Get("Content-Type")) == 0 {
+		req.header.Set("Content-Type", contentType)
+	}
+
+	return
+}
+
+func (p *MultipartBody) encode() (body []byte, contentType string, err error) {
+
+	buf := bytes.NewBuffer(nil)
+	writer := multipart.NewWriter(buf)
+
+	keys := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		err = writer.WriteField(k, p.Fields[k])
+		if err != nil {
+			return
+		}
+	}
+
+	for _, file := range p.Files {
+		if len(file.Field) == 0 {
+			err = fmt.Errorf("[fetcher-http]: params of multipart file field is empty")
+			return
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.Field), escapeQuotes(file.FileName)))
+
+		if len(file.ContentType) > 0 {
+			header.Set("Content-Type", file.ContentType)
+		} else {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+
+		var part io.Writer
+
+		part, err = writer.CreatePart(header)
+		if err != nil {
+			return
+		}
+
+		_, err = part.Write(file.Data)
+		if err != nil {
+			return
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return
+	}
+
+	body = buf.Bytes()
+	contentType = writer.FormDataContentType()
+
+	return
+}
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+func TestFetchMethodAndBody(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		if req.Method != http.MethodPut ||
+			req.URL.Query().Get("id") != "1" ||
+			req.Header.Get("Content-Type") != "application/json; charset=utf-8" ||
+			string(body) != `{"name":"report"}` {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+	}))
+	defer srv.Close()
+
+	f, err := NewHttpFetcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ := json.Marshal(Params{
+		URL:    srv.URL,
+		Method: "put",
+		Query:  map[string]string{"id": "1"},
+		JSON:   json.RawMessage(`{"name":"report"}`),
+	})
+
+	data, err := f.Fetch(fetcher.FetchParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "created" {
+		t.Errorf("unexpected result %q", data)
+	}
+
+	params, _ = json.Marshal(Params{
+		URL:          srv.URL,
+		Method:       "put",
+		Query:        map[string]string{"id": "1"},
+		JSON:         json.RawMessage(`{"name":"report"}`),
+		AcceptStatus: []int{http.StatusOK},
+	})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+	if err == nil {
+		t.Error("expected status 201 to be rejected")
+	}
+
+	params, _ = json.Marshal(Params{
+		URL:  srv.URL,
+		Data: []byte("raw"),
+		Form: map[string]string{"k": "v"},
+	})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+	if err == nil {
+		t.Error("expected data and form to be rejected")
+	}
+}
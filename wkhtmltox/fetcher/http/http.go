@@ -2,25 +2,57 @@ package http
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gogap/config"
 	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	supportedMethods = map[string]bool{
+		http.MethodGet:    true,
+		http.MethodPost:   true,
+		http.MethodPut:    true,
+		http.MethodPatch:  true,
+		http.MethodDelete: true,
+	}
 )
 
 type HttpFetcher struct {
-	client *http.Client
+	client       *http.Client
+	retry        retryPolicy
+	acceptStatus []int
+	auths        map[string]*authProfile
+	baseMode     string
 }
 
 type Params struct {
-	URL     string            `json:"url"`
-	Method  string            `json:"method"`
-	Headers map[string]string `json:"headers"`
-	Data    []byte            `json:"data"`
-	Replace map[string]string `json:"replace"`
+	URL          string            `json:"url"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	Query        map[string]string `json:"query"`         // Query parameters append to url
+	Data         []byte            `json:"data"`          // Raw body
+	JSON         json.RawMessage   `json:"json"`          // JSON body, content type is application/json
+	Form         map[string]string `json:"form"`          // Form body, content type is application/x-www-form-urlencoded
+	Multipart    *MultipartBody    `json:"multipart"`     // Multipart body, content type is multipart/form-data
+	AcceptStatus []int             `json:"accept_status"` // Accepted response status codes, default is any 2xx
+	Auth         string            `json:"auth"`          // Auth profile name in fetcher options
+	Replace      map[string]string `json:"replace"`       // Deprecated: literal replace, use rewrite instead
+	Rewrite      []RewriteRule     `json:"rewrite"`       // Ordered rewrite rules applied to the fetched document
+	Base         string            `json:"base"`          // How to resolve relative urls: inject, absolutize or none, default is the fetcher options
+	BaseURL      string            `json:"base_url"`      // Resolve relative urls against this url instead of the fetched url
+	Retry        *RetryOptions     `json:"retry"`
 }
 
 func (p *Params) Validation() (err error) {
@@ -35,11 +67,30 @@ func (p *Params) Validation() (err error) {
 		p.Method = "GET"
 	}
 
-	if p.Method != "GET" && p.Method != "POST" {
+	if !supportedMethods[p.Method] {
 		err = fmt.Errorf("[fetcher-http]: method %s not support", p.Method)
 		return
 	}
 
+	if p.bodyCount() > 1 {
+		err = fmt.Errorf("[fetcher-http]: params of data, json, form and multipart could not be used together")
+		return
+	}
+
+	p.Base = strings.ToLower(p.Base)
+
+	if len(p.Base) > 0 && !validBaseMode(p.Base) {
+		err = fmt.Errorf("[fetcher-http]: base %s not support", p.Base)
+		return
+	}
+
+	for i := 0; i < len(p.Rewrite); i++ {
+		err = p.Rewrite[i].validation()
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -52,15 +103,57 @@ func init() {
 }
 
 func NewHttpFetcher(conf config.Configuration) (httpFetcher fetcher.Fetcher, err error) {
-	httpClient := &http.Client{}
+	httpClient := &http.Client{CheckRedirect: checkRedirect}
+
+	var retryConf, authConf config.Configuration
+	var acceptStatus []int
+
+	baseMode := BaseNone
+
+	if conf != nil {
+		retryConf = conf.GetConfig("retry")
+		authConf = conf.GetConfig("auth")
+		baseMode = strings.ToLower(conf.GetString("base", BaseNone))
+
+		for _, code := range conf.GetInt32List("accept-status") {
+			acceptStatus = append(acceptStatus, int(code))
+		}
+	}
+
+	if !validBaseMode(baseMode) {
+		err = fmt.Errorf("[fetcher-http]: base %s not support", baseMode)
+		return
+	}
+
+	auths, err := newAuthenticators(authConf, httpClient)
+	if err != nil {
+		return
+	}
+
 	httpFetcher = &HttpFetcher{
-		client: httpClient,
+		client:       httpClient,
+		retry:        newRetryPolicy(retryConf),
+		acceptStatus: acceptStatus,
+		auths:        auths,
+		baseMode:     baseMode,
 	}
 	return
 }
 
 func (p *HttpFetcher) Fetch(fetchParams fetcher.FetchParams) (data []byte, err error) {
 
+	doc, err := p.FetchStream(context.Background(), fetchParams)
+	if err != nil {
+		return
+	}
+
+	data, err = doc.ReadAll()
+
+	return
+}
+
+func (p *HttpFetcher) FetchStream(ctx context.Context, fetchParams fetcher.FetchParams) (doc *fetcher.Document, err error) {
+
 	params := Params{}
 
 	err = fetchParams.Unmarshal(&params)
@@ -70,52 +163,252 @@ func (p *HttpFetcher) Fetch(fetchParams fetcher.FetchParams) (data []byte, err e
 
 	err = params.Validation()
 	if err != nil {
+		err = fetcher.InvalidParams(err)
 		return
 	}
 
-	data, err = p.send(params)
+	doc, err = p.send(ctx, params)
 
 	return
 }
 
-func (p *HttpFetcher) send(params Params) (data []byte, err error) {
+func (p *HttpFetcher) send(ctx context.Context, params Params) (doc *fetcher.Document, err error) {
+
+	policy, err := p.retry.merge(params.Retry)
+	if err != nil {
+		err = fetcher.InvalidParams(err)
+		return
+	}
+
+	req, err := params.toRequest()
+	if err != nil {
+		err = fetcher.InvalidParams(err)
+		return
+	}
+
+	if len(params.Auth) > 0 {
+		profile, exist := p.auths[params.Auth]
+		if !exist {
+			err = fetcher.InvalidParams(fmt.Errorf("[fetcher-http]: auth profile %s not exist", params.Auth))
+			return
+		}
+
+		var u *url.URL
+		if u, err = url.Parse(req.url); err != nil || !profile.allowed(u) {
+			err = fetcher.InvalidParams(fmt.Errorf("[fetcher-http]: url %s is not allowed by auth profile %s", req.url, params.Auth))
+			return
+		}
+
+		req.auth = profile.auth
+		req.authProfile = profile
+	}
+
+	acceptStatus := p.acceptStatus
+	if len(params.AcceptStatus) > 0 {
+		acceptStatus = params.AcceptStatus
+	}
+
+	baseMode := p.baseMode
+	if len(params.Base) > 0 {
+		baseMode = params.Base
+	}
+
+	// the body is streamed to the converter while it is not processed, the
+	// failure while reading it could not be retried then
+	stream := len(params.Replace) == 0 && len(params.Rewrite) == 0 && baseMode == BaseNone
+
+	attempt := 0
+	reauthorized := false
+
+	var resp response
+
+	for {
+		attempt++
+
+		var retryable bool
+		var retryAfter time.Duration
+
+		resp, retryable, retryAfter, err = p.sendOnce(ctx, req, acceptStatus, policy, stream)
+
+		// the cached credential could be revoked before it expires, send once
+		// more with a fresh one, which is not counted as an attempt
+		if err != nil && resp.statusCode == http.StatusUnauthorized && !reauthorized && ctx.Err() == nil {
+			if _, ok := req.auth.(invalidator); ok {
+				reauthorized = true
+				attempt--
+				continue
+			}
+		}
+
+		if err == nil || !retryable || !policy.retryableMethod(req.method) || attempt >= policy.maxAttempts || ctx.Err() != nil {
+			break
+		}
 
-	body := bytes.NewBuffer(params.Data)
+		select {
+		case <-ctx.Done():
+		case <-time.After(policy.wait(attempt, retryAfter)):
+		}
+	}
 
-	req, err := http.NewRequest(params.Method, params.URL, body)
+	if err != nil && policy.maxAttempts > 1 {
+		err = fmt.Errorf("%s, attempts %d/%d", err.Error(), attempt, policy.maxAttempts)
+		return
+	}
 
 	if err != nil {
 		return
 	}
 
-	if len(params.Headers) > 0 {
-		for k, v := range params.Headers {
-			req.Header.Set(k, v)
+	baseURL := resp.url
+	if len(params.BaseURL) > 0 {
+		baseURL = params.BaseURL
+	}
+
+	if resp.body != nil {
+		doc = fetcher.NewStreamDocument(resp.body, fetcher.Metadata{
+			ContentType: resp.contentType,
+			SourceURL:   baseURL,
+			Size:        resp.size,
+		})
+		return
+	}
+
+	data := resp.data
+	contentType := resp.contentType
+
+	// the document will be decoded to UTF-8 while it is processed
+	if len(params.Replace) > 0 || len(params.Rewrite) > 0 || (baseMode != BaseNone && isHTML(data, contentType)) {
+		data, contentType, err = fetcher.ToUTF8(data, contentType)
+		if err != nil {
+			return
 		}
 	}
 
-	resp, err := p.client.Do(req)
+	data, err = rewrite(data, contentType, params.Replace, params.Rewrite)
+	if err != nil {
+		return
+	}
 
+	data, err = resolveBase(data, contentType, baseMode, baseURL)
 	if err != nil {
 		return
 	}
 
-	defer resp.Body.Close()
+	doc = fetcher.NewDocument(data, fetcher.Metadata{
+		ContentType: contentType,
+		SourceURL:   baseURL,
+	})
+
+	return
+}
+
+// sendOnce sends the request, the body of the accepted response is returned
+// unread while stream, the caller must close it
+func (p *HttpFetcher) sendOnce(ctx context.Context, r request, acceptStatus []int, policy retryPolicy, stream bool) (ret response, retryable bool, retryAfter time.Duration, err error) {
+
+	req, err := http.NewRequest(r.method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return
+	}
+
+	req = req.WithContext(ctx)
+
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+
+	// the upstream could join its logs with ours by the request id
+	if id := logging.RequestID(ctx); len(id) > 0 && len(req.Header.Get(logging.HeaderRequestID)) == 0 {
+		req.Header.Set(logging.HeaderRequestID, id)
+	}
+
+	// the w3c traceparent of the current span, nothing is injected while tracing is disabled
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if r.auth != nil {
+		err = r.auth.authenticate(req, r.body)
+		if err != nil {
+			return
+		}
+	}
+
+	if r.authProfile != nil {
+		req = req.WithContext(context.WithValue(req.Context(), authProfileKey{}, r.authProfile))
+	}
+
+	resp, err := p.client.Do(req)
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("[fetcher-http]: fetch url by %s failure <%s>, status code is %d", params.Method, params.URL, resp.StatusCode)
+	if err != nil {
+		retryable = policy.networkErrors && ctx.Err() == nil
 		return
 	}
 
-	data, err = ioutil.ReadAll(resp.Body)
+	ret.contentType = resp.Header.Get("Content-Type")
+	ret.url = resp.Request.URL.String()
+	ret.statusCode = resp.StatusCode
+
+	defer func() {
+		if ret.body == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if i, ok := r.auth.(invalidator); ok {
+			i.invalidate()
+		}
+	}
+
+	if !statusAccepted(resp.StatusCode, acceptStatus) {
+		retryable = policy.retryableStatus(resp.StatusCode)
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		err = fmt.Errorf("[fetcher-http]: fetch url by %s failure <%s>, status code is %d", r.method, r.url, resp.StatusCode)
+		return
+	}
 
-	for k, v := range params.Replace {
-		data = bytes.Replace(data, []byte(k), []byte(v), -1)
+	if stream {
+		ret.body = resp.Body
+		ret.size = resp.ContentLength
+		return
 	}
 
+	ret.data, err = ioutil.ReadAll(resp.Body)
+
 	if err != nil {
+		retryable = policy.networkErrors
 		return
 	}
 
 	return
 }
+
+type authProfileKey struct{}
+
+// checkRedirect keeps the default limit of redirects, and refuses the
+// redirects of the authenticated request to the hosts not allowed by its
+// profile, the custom headers, e.g. of hmac, are kept while redirecting
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if profile, ok := req.Context().Value(authProfileKey{}).(*authProfile); ok && !profile.allowed(req.URL) {
+		return fmt.Errorf("redirect to %s is not allowed by the auth profile", req.URL.Host)
+	}
+
+	return nil
+}
+
+func statusAccepted(code int, acceptStatus []int) bool {
+	if len(acceptStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, c := range acceptStatus {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
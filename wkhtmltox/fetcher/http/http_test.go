@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
+)
+
+func TestFetchForwardRequestID(t *testing.T) {
+
+	ids := make(chan string, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ids <- req.Header.Get(logging.HeaderRequestID)
+		rw.Write([]byte("<html></html>"))
+	}))
+	defer srv.Close()
+
+	f, err := NewHttpFetcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sf := fetcher.ToStream(f)
+
+	params, _ := json.Marshal(Params{URL: srv.URL})
+
+	doc, err := sf.FetchStream(logging.WithRequestID(context.Background(), "req-1"), fetcher.FetchParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Close()
+
+	if id := <-ids; id != "req-1" {
+		t.Errorf("expected request id req-1 forwarded, got %q", id)
+	}
+
+	// the header given by the params is kept
+	params, _ = json.Marshal(Params{URL: srv.URL, Headers: map[string]string{logging.HeaderRequestID: "upstream-1"}})
+
+	doc, err = sf.FetchStream(logging.WithRequestID(context.Background(), "req-2"), fetcher.FetchParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Close()
+
+	if id := <-ids; id != "upstream-1" {
+		t.Errorf("expected request id of params kept, got %q", id)
+	}
+}
+
+func TestFetchStreamBody(t *testing.T) {
+
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte("<html>"))
+		rw.(http.Flusher).Flush()
+
+		<-release
+		rw.Write([]byte("</html>"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	f, err := NewHttpFetcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ := json.Marshal(Params{URL: srv.URL})
+
+	// the document is returned before the upstream finishes the body
+	doc, err := fetcher.ToStream(f).FetchStream(context.Background(), fetcher.FetchParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	if doc.Charset != "utf-8" || doc.Size != -1 {
+		t.Errorf("unexpected metadata %+v", doc.Metadata)
+	}
+
+	buf := make([]byte, 6)
+	if _, err = io.ReadFull(doc, buf); err != nil || string(buf) != "<html>" {
+		t.Errorf("unexpected head of body %q, %v", buf, err)
+	}
+}
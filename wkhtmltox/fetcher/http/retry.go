@@ -0,0 +1,221 @@
+package http
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+var (
+	defaultRetryStatusCodes = []int{
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+// RetryOptions is the per request override of the fetcher retry policy,
+// zero value fields keep the value from fetcher options, the attempts and
+// the backoffs are capped by the fetcher options
+type RetryOptions struct {
+	MaxAttempts   int      `json:"max_attempts"`   // Total attempts, include the first request
+	Backoff       string   `json:"backoff"`        // Base backoff duration, e.g. 200ms
+	MaxBackoff    string   `json:"max_backoff"`    // Upper bound of a single wait, e.g. 5s
+	Jitter        *float64 `json:"jitter"`         // Random factor of backoff (between 0 and 1), 0 disables the jitter
+	StatusCodes   []int    `json:"status_codes"`   // Response status codes which should retry
+	NetworkErrors *bool    `json:"network_errors"` // Retry on network errors
+}
+
+type retryPolicy struct {
+	maxAttempts   int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	jitter        float64
+	statusCodes   map[int]bool
+	networkErrors bool
+	nonIdempotent bool // retry POST and PATCH as well, they may be applied twice by the upstream
+}
+
+func newRetryPolicy(conf config.Configuration) (policy retryPolicy) {
+
+	policy = retryPolicy{
+		maxAttempts:   1,
+		backoff:       time.Millisecond * 200,
+		maxBackoff:    time.Second * 5,
+		jitter:        0.2,
+		statusCodes:   codesToMap(defaultRetryStatusCodes),
+		networkErrors: true,
+	}
+
+	if conf == nil {
+		return
+	}
+
+	policy.maxAttempts = int(conf.GetInt32("max-attempts", 1))
+	policy.backoff = conf.GetTimeDuration("backoff", policy.backoff)
+	policy.maxBackoff = conf.GetTimeDuration("max-backoff", policy.maxBackoff)
+	policy.jitter = conf.GetFloat64("jitter", policy.jitter)
+	policy.networkErrors = conf.GetBoolean("network-errors", true)
+	policy.nonIdempotent = conf.GetBoolean("non-idempotent", false)
+
+	if codes := conf.GetInt32List("status-codes"); len(codes) > 0 {
+		policy.statusCodes = make(map[int]bool)
+		for _, code := range codes {
+			policy.statusCodes[int(code)] = true
+		}
+	}
+
+	return
+}
+
+// merge applies the per request options, they could only tighten the
+// options of the fetcher, the fetching holds a slot of the queue
+func (p retryPolicy) merge(opts *RetryOptions) (policy retryPolicy, err error) {
+
+	policy = p
+
+	if opts == nil {
+		return
+	}
+
+	if opts.MaxAttempts < 0 {
+		err = fmt.Errorf("[fetcher-http]: params of retry.max_attempts is illegal, %d", opts.MaxAttempts)
+		return
+	}
+
+	if opts.MaxAttempts > 0 && opts.MaxAttempts < policy.maxAttempts {
+		policy.maxAttempts = opts.MaxAttempts
+	}
+
+	if len(opts.Backoff) > 0 {
+		var backoff time.Duration
+		backoff, err = parsePositiveDuration("retry.backoff", opts.Backoff)
+		if err != nil {
+			return
+		}
+
+		if backoff < policy.backoff {
+			policy.backoff = backoff
+		}
+	}
+
+	if len(opts.MaxBackoff) > 0 {
+		var maxBackoff time.Duration
+		maxBackoff, err = parsePositiveDuration("retry.max_backoff", opts.MaxBackoff)
+		if err != nil {
+			return
+		}
+
+		if policy.maxBackoff <= 0 || maxBackoff < policy.maxBackoff {
+			policy.maxBackoff = maxBackoff
+		}
+	}
+
+	if opts.Jitter != nil {
+		if *opts.Jitter < 0 || *opts.Jitter > 1 {
+			err = fmt.Errorf("[fetcher-http]: params of retry.jitter should be between 0 and 1")
+			return
+		}
+		policy.jitter = *opts.Jitter
+	}
+
+	if len(opts.StatusCodes) > 0 {
+		policy.statusCodes = codesToMap(opts.StatusCodes)
+	}
+
+	if opts.NetworkErrors != nil {
+		policy.networkErrors = *opts.NetworkErrors
+	}
+
+	return
+}
+
+func parsePositiveDuration(name, value string) (d time.Duration, err error) {
+	d, err = time.ParseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("[fetcher-http]: params of %s is illegal, %s", name, err.Error())
+		return
+	}
+
+	if d <= 0 {
+		err = fmt.Errorf("[fetcher-http]: params of %s should be positive, got %s", name, value)
+	}
+
+	return
+}
+
+// wait returns the duration before the next attempt, retryAfter comes from
+// the Retry-After header of the last response and takes priority
+func (p retryPolicy) wait(attempt int, retryAfter time.Duration) time.Duration {
+
+	if retryAfter > 0 {
+		if p.maxBackoff > 0 && retryAfter > p.maxBackoff {
+			return p.maxBackoff
+		}
+		return retryAfter
+	}
+
+	d := p.backoff << uint(attempt-1)
+
+	if p.maxBackoff > 0 && (d > p.maxBackoff || d <= 0) {
+		d = p.maxBackoff
+	}
+
+	if p.jitter > 0 {
+		jitter := p.jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d = d - time.Duration(jitter*rand.Float64()*float64(d))
+	}
+
+	return d
+}
+
+func (p retryPolicy) retryableStatus(code int) bool {
+	return p.statusCodes[code]
+}
+
+// retryableMethod reports whether the request of method could be sent again,
+// the non idempotent methods are retried only while the fetcher opts in
+func (p retryPolicy) retryableMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return p.nonIdempotent
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+func codesToMap(codes []int) map[int]bool {
+	m := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		m[code] = true
+	}
+	return m
+}
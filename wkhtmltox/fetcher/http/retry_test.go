@@ -0,0 +1,183 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+func TestFetchRetry(t *testing.T) {
+
+	var hits int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("<html></html>"))
+	}))
+	defer srv.Close()
+
+	f, err := NewHttpFetcher(config.NewConfig(config.ConfigString(`retry { max-attempts = 3 }`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, _ := json.Marshal(Params{
+		URL:   srv.URL,
+		Retry: &RetryOptions{MaxAttempts: 3, Backoff: "1ms"},
+	})
+
+	data, err := f.Fetch(fetcher.FetchParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "<html></html>" || hits != 3 {
+		t.Errorf("unexpected result %q after %d hits", data, hits)
+	}
+
+	atomic.StoreInt32(&hits, -10)
+
+	params, _ = json.Marshal(Params{
+		URL:   srv.URL,
+		Retry: &RetryOptions{MaxAttempts: 2, Backoff: "1ms"},
+	})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+	if err == nil || !strings.Contains(err.Error(), "attempts 2/2") {
+		t.Errorf("expected attempts in error, got %v", err)
+	}
+}
+
+func TestFetchRetryNonIdempotent(t *testing.T) {
+
+	var hits int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	params, _ := json.Marshal(Params{
+		URL:    srv.URL,
+		Method: "post",
+		Retry:  &RetryOptions{MaxAttempts: 3, Backoff: "1ms"},
+	})
+
+	for _, c := range []struct {
+		conf string
+		hits int32
+	}{
+		{"retry { max-attempts = 3 }", 1},
+		{"retry { max-attempts = 3, non-idempotent = true }", 3},
+	} {
+		atomic.StoreInt32(&hits, 0)
+
+		f, err := NewHttpFetcher(config.NewConfig(config.ConfigString(c.conf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.Fetch(fetcher.FetchParams(params))
+		if err == nil || hits != c.hits {
+			t.Errorf("options %q: expected %d hits, got %d %v", c.conf, c.hits, hits, err)
+		}
+	}
+}
+
+func TestRetryJitterOverride(t *testing.T) {
+
+	policy := newRetryPolicy(config.NewConfig(config.ConfigString(`jitter = 0.5`)))
+
+	zero := 0.0
+
+	merged, err := policy.merge(&RetryOptions{Jitter: &zero, Backoff: "100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the jitter of 0 in params disables the configured jitter
+	for i := 0; i < 10; i++ {
+		if d := merged.wait(1, 0); d != 100*time.Millisecond {
+			t.Fatalf("expected wait 100ms without jitter, got %s", d)
+		}
+	}
+
+	merged, _ = policy.merge(&RetryOptions{})
+	if merged.jitter != 0.5 {
+		t.Errorf("expected jitter of options kept, got %f", merged.jitter)
+	}
+}
+
+func TestRetryOptionsCapped(t *testing.T) {
+
+	policy := newRetryPolicy(config.NewConfig(config.ConfigString(`
+		max-attempts = 3
+		backoff      = 100ms
+		max-backoff  = 1s`)))
+
+	merged, err := policy.merge(&RetryOptions{MaxAttempts: 1000, Backoff: "1h", MaxBackoff: "10h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged.maxAttempts != 3 || merged.backoff != 100*time.Millisecond || merged.maxBackoff != time.Second {
+		t.Errorf("expected the options capped by the fetcher, got %+v", merged)
+	}
+
+	merged, err = policy.merge(&RetryOptions{MaxAttempts: 2, Backoff: "10ms", MaxBackoff: "200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged.maxAttempts != 2 || merged.backoff != 10*time.Millisecond || merged.maxBackoff != 200*time.Millisecond {
+		t.Errorf("expected the tighter options applied, got %+v", merged)
+	}
+
+	for _, opts := range []RetryOptions{
+		{MaxAttempts: -1},
+		{Backoff: "-1s"},
+		{Backoff: "0s"},
+		{MaxBackoff: "-5m"},
+	} {
+		if _, err = policy.merge(&opts); err == nil {
+			t.Errorf("expected %+v refused", opts)
+		}
+	}
+
+	// the error of the params is responded as invalid options
+	f, _ := NewHttpFetcher(nil)
+	params, _ := json.Marshal(Params{URL: "http://127.0.0.1", Retry: &RetryOptions{Backoff: "-1s"}})
+
+	_, err = f.Fetch(fetcher.FetchParams(params))
+
+	var paramsErr *fetcher.ParamsError
+	if !errors.As(err, &paramsErr) {
+		t.Errorf("expected illegal params, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != time.Second*3 {
+		t.Errorf("expected 3s, got %s", d)
+	}
+
+	if d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); d <= 0 || d > time.Minute {
+		t.Errorf("unexpected duration %s", d)
+	}
+
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("expected 0, got %s", d)
+	}
+}
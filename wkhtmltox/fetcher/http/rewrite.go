@@ -0,0 +1,199 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+const (
+	RewriteRegex        = "regex"         // Replace the matches of pattern by replacement, support $1, ${name}
+	RewriteLiteral      = "literal"       // Replace the pattern text by replacement
+	RewriteRemove       = "remove"        // Remove the elements matched by selector
+	RewriteSetAttr      = "set_attr"      // Set attr of the elements matched by selector to value
+	RewriteRemoveAttr   = "remove_attr"   // Remove attr of the elements matched by selector
+	RewriteInjectBase   = "inject_base"   // Inject or replace <base href="value"> in head
+	RewriteInjectStyle  = "inject_style"  // Append <style>value</style> to head
+	RewriteInjectScript = "inject_script" // Append <script src="src">value</script> to body
+)
+
+type RewriteRule struct {
+	Type        string `json:"type"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	Selector    string `json:"selector"`
+	Attr        string `json:"attr"`
+	Value       string `json:"value"`
+	Src         string `json:"src"`
+
+	re *regexp.Regexp
+}
+
+func (p *RewriteRule) isDOM() bool {
+	return p.Type != RewriteRegex && p.Type != RewriteLiteral
+}
+
+func (p *RewriteRule) validation() (err error) {
+
+	switch p.Type {
+	case RewriteRegex:
+		{
+			p.re, err = regexp.Compile(p.Pattern)
+			if err != nil {
+				err = fmt.Errorf("[fetcher-http]: rewrite pattern %s is illegal, %s", p.Pattern, err.Error())
+				return
+			}
+		}
+	case RewriteLiteral:
+		{
+			if len(p.Pattern) == 0 {
+				err = fmt.Errorf("[fetcher-http]: rewrite pattern of %s is empty", p.Type)
+				return
+			}
+		}
+	case RewriteRemove, RewriteSetAttr, RewriteRemoveAttr:
+		{
+			if len(p.Selector) == 0 {
+				err = fmt.Errorf("[fetcher-http]: rewrite selector of %s is empty", p.Type)
+				return
+			}
+
+			if p.Type != RewriteRemove && len(p.Attr) == 0 {
+				err = fmt.Errorf("[fetcher-http]: rewrite attr of %s is empty", p.Type)
+				return
+			}
+		}
+	case RewriteInjectBase, RewriteInjectStyle:
+		{
+			if len(p.Value) == 0 {
+				err = fmt.Errorf("[fetcher-http]: rewrite value of %s is empty", p.Type)
+				return
+			}
+		}
+	case RewriteInjectScript:
+		{
+			if len(p.Value) == 0 && len(p.Src) == 0 {
+				err = fmt.Errorf("[fetcher-http]: rewrite value or src of %s is empty", p.Type)
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("[fetcher-http]: rewrite type %s not support", p.Type)
+		return
+	}
+
+	return
+}
+
+func (p *RewriteRule) applyText(data []byte) []byte {
+	if p.Type == RewriteRegex {
+		return p.re.ReplaceAll(data, []byte(p.Replacement))
+	}
+
+	return bytes.Replace(data, []byte(p.Pattern), []byte(p.Replacement), -1)
+}
+
+func (p *RewriteRule) applyDOM(doc *goquery.Document) {
+
+	switch p.Type {
+	case RewriteRemove:
+		doc.Find(p.Selector).Remove()
+	case RewriteSetAttr:
+		doc.Find(p.Selector).SetAttr(p.Attr, p.Value)
+	case RewriteRemoveAttr:
+		doc.Find(p.Selector).RemoveAttr(p.Attr)
+	case RewriteInjectBase:
+		{
+			base := doc.Find("head base")
+			if base.Length() > 0 {
+				base.First().SetAttr("href", p.Value)
+			} else {
+				doc.Find("head").PrependHtml(`<base href="` + html.EscapeString(p.Value) + `"/>`)
+			}
+		}
+	case RewriteInjectStyle:
+		doc.Find("head").AppendHtml("<style>" + p.Value + "</style>")
+	case RewriteInjectScript:
+		{
+			script := "<script"
+			if len(p.Src) > 0 {
+				script += ` src="` + html.EscapeString(p.Src) + `"`
+			}
+			script += ">" + p.Value + "</script>"
+
+			doc.Find("body").AppendHtml(script)
+		}
+	}
+}
+
+// rewrite transcodes the document to UTF-8 according to the content type
+// and the meta of document, then applies the rules in order
+func rewrite(data []byte, contentType string, replace map[string]string, rules []RewriteRule) (ret []byte, err error) {
+
+	if len(replace) == 0 && len(rules) == 0 {
+		ret = data
+		return
+	}
+
+	data, _, err = fetcher.ToUTF8(data, contentType)
+	if err != nil {
+		return
+	}
+
+	for k, v := range replace {
+		data = bytes.Replace(data, []byte(k), []byte(v), -1)
+	}
+
+	var doc *goquery.Document
+
+	for i := 0; i < len(rules); i++ {
+
+		if !rules[i].isDOM() {
+			if doc != nil {
+				data, err = renderDocument(doc)
+				if err != nil {
+					return
+				}
+				doc = nil
+			}
+
+			data = rules[i].applyText(data)
+			continue
+		}
+
+		if doc == nil {
+			doc, err = goquery.NewDocumentFromReader(bytes.NewReader(data))
+			if err != nil {
+				return
+			}
+		}
+
+		rules[i].applyDOM(doc)
+	}
+
+	if doc != nil {
+		data, err = renderDocument(doc)
+		if err != nil {
+			return
+		}
+	}
+
+	ret = data
+
+	return
+}
+
+func renderDocument(doc *goquery.Document) (data []byte, err error) {
+	str, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return
+	}
+
+	data = []byte(str)
+
+	return
+}
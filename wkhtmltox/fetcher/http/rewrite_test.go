@@ -0,0 +1,47 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+
+	rules := []RewriteRule{
+		{Type: RewriteRegex, Pattern: `Order #(\d+)`, Replacement: "Invoice ${1}"},
+		{Type: RewriteRemove, Selector: "script"},
+		{Type: RewriteSetAttr, Selector: "img", Attr: "width", Value: "100"},
+		{Type: RewriteInjectBase, Value: "https://example.com/reports/"},
+		{Type: RewriteInjectStyle, Value: "body{margin:0}"},
+	}
+
+	for i := 0; i < len(rules); i++ {
+		if err := rules[i].validation(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc := `<html><head><title>Order #42</title><script>alert(1)</script></head><body><img src="a.png"/></body></html>`
+
+	data, err := rewrite([]byte(doc), "text/html", nil, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := string(data)
+
+	for _, expected := range []string{
+		"<title>Invoice 42</title>",
+		`<base href="https://example.com/reports/"/>`,
+		"<style>body{margin:0}</style>",
+		`<img src="a.png" width="100"/>`,
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %s in %s", expected, result)
+		}
+	}
+
+	if strings.Contains(result, "<script>") {
+		t.Errorf("expected script to be removed, got %s", result)
+	}
+}
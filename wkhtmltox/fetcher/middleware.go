@@ -0,0 +1,60 @@
+package fetcher
+
+import (
+	"fmt"
+
+	"github.com/gogap/config"
+)
+
+// Middleware wraps a StreamFetcher to process the fetched document
+type Middleware func(StreamFetcher) StreamFetcher
+
+type NewMiddlewareFunc func(config.Configuration) (Middleware, error)
+
+var (
+	newMiddlewareFuncs = make(map[string]NewMiddlewareFunc)
+)
+
+func NewMiddleware(name string, conf config.Configuration) (m Middleware, err error) {
+	fn, exist := newMiddlewareFuncs[name]
+	if !exist {
+		err = fmt.Errorf("fetcher middleware of %s not exist", name)
+		return
+	}
+
+	return fn(conf)
+}
+
+func RegisterMiddleware(name string, fn NewMiddlewareFunc) (err error) {
+
+	if len(name) == 0 {
+		err = fmt.Errorf("fetcher middleware name is empty")
+		return
+	}
+
+	if fn == nil {
+		err = fmt.Errorf("the fetcher middleware of %s's new func is nil", name)
+		return
+	}
+
+	_, exist := newMiddlewareFuncs[name]
+
+	if exist {
+		err = fmt.Errorf("middleware of %s already exist", name)
+		return
+	}
+
+	newMiddlewareFuncs[name] = fn
+
+	return
+}
+
+// Chain wraps f by middlewares, the document from f is processed by
+// middlewares in order
+func Chain(f StreamFetcher, middlewares ...Middleware) StreamFetcher {
+	for _, m := range middlewares {
+		f = m(f)
+	}
+
+	return f
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+// NewCharset transcodes the document to UTF-8 by the content type or the
+// meta of document
+func NewCharset(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	m = newTransformer(func(doc *fetcher.Document) (ret *fetcher.Document, err error) {
+
+		data, err := doc.ReadAll()
+		if err != nil {
+			return
+		}
+
+		data, contentType, err := fetcher.ToUTF8(data, doc.ContentType)
+		if err != nil {
+			err = fmt.Errorf("[middleware-charset]: %s", err.Error())
+			return
+		}
+
+		meta := doc.Metadata
+		meta.ContentType = contentType
+		meta.Charset = "utf-8"
+		meta.LocalPath = ""
+
+		ret = fetcher.NewDocument(data, meta)
+
+		return
+	})
+
+	return
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+// NewDecompress decompresses the document, options:
+//
+//	encoding = auto # auto, gzip, deflate, br
+//
+// auto detects gzip and deflate by the magic bytes, brotli has no magic
+// bytes so it must be configured explicitly
+func NewDecompress(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	encoding := "auto"
+
+	if conf != nil {
+		encoding = strings.ToLower(conf.GetString("encoding", encoding))
+	}
+
+	switch encoding {
+	case "auto", "gzip", "deflate", "br":
+	default:
+		err = fmt.Errorf("[middleware-decompress]: encoding %s not support", encoding)
+		return
+	}
+
+	m = newTransformer(func(doc *fetcher.Document) (ret *fetcher.Document, err error) {
+
+		br := bufio.NewReader(doc)
+
+		enc := encoding
+
+		if enc == "auto" {
+			enc = detectCompression(br)
+		}
+
+		switch enc {
+		case "gzip":
+			{
+				var r *gzip.Reader
+				r, err = gzip.NewReader(br)
+				if err != nil {
+					err = fmt.Errorf("[middleware-decompress]: %s", err.Error())
+					return
+				}
+				ret = replaceReader(doc, r, r)
+			}
+		case "deflate":
+			{
+				var r io.ReadCloser
+				r, err = zlib.NewReader(br)
+				if err != nil {
+					err = fmt.Errorf("[middleware-decompress]: %s", err.Error())
+					return
+				}
+				ret = replaceReader(doc, r, r)
+			}
+		case "br":
+			ret = replaceReader(doc, brotli.NewReader(br))
+		default:
+			ret = replaceReader(doc, br)
+			ret.Size = doc.Size
+			ret.LocalPath = doc.LocalPath
+		}
+
+		return
+	})
+
+	return
+}
+
+func detectCompression(br *bufio.Reader) string {
+	magic, _ := br.Peek(2)
+
+	if len(magic) < 2 {
+		return ""
+	}
+
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return "gzip"
+	}
+
+	// zlib header, CM is 8 and the header checksum is multiple of 31
+	if magic[0]&0x0f == 0x08 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0 {
+		return "deflate"
+	}
+
+	return ""
+}
+
+// NewBase64 decodes the base64 encoded document, options:
+//
+//	encoding = std # std, url, raw-std, raw-url
+func NewBase64(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	encodingName := "std"
+
+	if conf != nil {
+		encodingName = strings.ToLower(conf.GetString("encoding", encodingName))
+	}
+
+	var encoding *base64.Encoding
+
+	switch encodingName {
+	case "std":
+		encoding = base64.StdEncoding
+	case "url":
+		encoding = base64.URLEncoding
+	case "raw-std":
+		encoding = base64.RawStdEncoding
+	case "raw-url":
+		encoding = base64.RawURLEncoding
+	default:
+		err = fmt.Errorf("[middleware-base64]: encoding %s not support", encodingName)
+		return
+	}
+
+	m = newTransformer(func(doc *fetcher.Document) (*fetcher.Document, error) {
+		ret := replaceReader(doc, base64.NewDecoder(encoding, doc))
+		ret.ContentType = ""
+		return ret, nil
+	})
+
+	return
+}
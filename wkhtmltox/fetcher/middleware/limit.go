@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+// NewMaxSize rejects the document larger than limit, options:
+//
+//	limit = 10485760 # bytes
+func NewMaxSize(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	var limit int64
+
+	if conf != nil {
+		limit = conf.GetInt64("limit")
+	}
+
+	if limit <= 0 {
+		err = fmt.Errorf("[middleware-max-size]: limit should be greater than 0")
+		return
+	}
+
+	m = newTransformer(func(doc *fetcher.Document) (ret *fetcher.Document, err error) {
+
+		if doc.Size > limit {
+			err = fmt.Errorf("[middleware-max-size]: document size %d exceeds limit of %d bytes", doc.Size, limit)
+			return
+		}
+
+		ret = replaceReader(doc, &limitedReader{r: doc, remain: limit, limit: limit})
+		ret.Size = doc.Size
+		ret.LocalPath = doc.LocalPath
+
+		return
+	})
+
+	return
+}
+
+type limitedReader struct {
+	r      io.Reader
+	remain int64
+	limit  int64
+}
+
+func (p *limitedReader) Read(b []byte) (n int, err error) {
+	if p.remain < 0 {
+		return 0, fmt.Errorf("[middleware-max-size]: document size exceeds limit of %d bytes", p.limit)
+	}
+
+	// read one more byte to find out whether the document exceeds the limit
+	if int64(len(b)) > p.remain+1 {
+		b = b[:p.remain+1]
+	}
+
+	n, err = p.r.Read(b)
+	p.remain -= int64(n)
+
+	if p.remain < 0 {
+		n += int(p.remain)
+		err = fmt.Errorf("[middleware-max-size]: document size exceeds limit of %d bytes", p.limit)
+	}
+
+	return
+}
+
+// NewContentType rejects the document of which content type is not allowed,
+// options:
+//
+//	allowed = ["text/html", "text/*"]
+//
+// the content type is sniffed from the document if it is unknown
+func NewContentType(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	var allowed []string
+
+	if conf != nil {
+		allowed = conf.GetStringList("allowed")
+	}
+
+	if len(allowed) == 0 {
+		err = fmt.Errorf("[middleware-content-type]: allowed content types is empty")
+		return
+	}
+
+	for i := 0; i < len(allowed); i++ {
+		allowed[i] = strings.ToLower(strings.TrimSpace(allowed[i]))
+	}
+
+	m = newTransformer(func(doc *fetcher.Document) (ret *fetcher.Document, err error) {
+
+		ret = doc
+
+		contentType := doc.ContentType
+
+		if len(contentType) == 0 {
+			br := bufio.NewReaderSize(doc, 512)
+			head, _ := br.Peek(512)
+
+			contentType = http.DetectContentType(head)
+
+			ret = replaceReader(doc, br)
+			ret.Size = doc.Size
+			ret.LocalPath = doc.LocalPath
+			ret.ContentType = contentType
+		}
+
+		mediaType, _, e := mime.ParseMediaType(contentType)
+		if e != nil {
+			err = fmt.Errorf("[middleware-content-type]: content type %s is illegal", contentType)
+			return
+		}
+
+		for _, pattern := range allowed {
+			if matchMediaType(pattern, mediaType) {
+				return
+			}
+		}
+
+		err = fmt.Errorf("[middleware-content-type]: content type %s is not allowed", mediaType)
+
+		return
+	})
+
+	return
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"io"
+
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+func init() {
+	middlewares := map[string]fetcher.NewMiddlewareFunc{
+		"decompress":   NewDecompress,
+		"base64":       NewBase64,
+		"charset":      NewCharset,
+		"sanitize":     NewSanitize,
+		"max-size":     NewMaxSize,
+		"content-type": NewContentType,
+	}
+
+	for name, fn := range middlewares {
+		err := fetcher.RegisterMiddleware(name, fn)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+type transformFunc func(doc *fetcher.Document) (*fetcher.Document, error)
+
+type transformer struct {
+	next      fetcher.StreamFetcher
+	transform transformFunc
+}
+
+func newTransformer(fn transformFunc) fetcher.Middleware {
+	return func(next fetcher.StreamFetcher) fetcher.StreamFetcher {
+		return &transformer{
+			next:      next,
+			transform: fn,
+		}
+	}
+}
+
+func (p *transformer) FetchStream(ctx context.Context, params fetcher.FetchParams) (doc *fetcher.Document, err error) {
+
+	doc, err = p.next.FetchStream(ctx, params)
+	if err != nil {
+		return
+	}
+
+	ret, err := p.transform(doc)
+	if err != nil {
+		doc.Close()
+		doc = nil
+		return
+	}
+
+	doc = ret
+
+	return
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// replaceReader returns a copy of doc reading from r, the content is changed
+// so the size and local path are no longer available
+func replaceReader(doc *fetcher.Document, r io.Reader, closers ...io.Closer) *fetcher.Document {
+
+	closer := io.Closer(doc.ReadCloser)
+
+	if len(closers) > 0 {
+		closer = multiCloser(append(closers, doc.ReadCloser))
+	}
+
+	meta := doc.Metadata
+	meta.Size = -1
+	meta.LocalPath = ""
+
+	return &fetcher.Document{
+		Metadata:   meta,
+		ReadCloser: readCloser{Reader: r, Closer: closer},
+	}
+}
+
+type multiCloser []io.Closer
+
+func (p multiCloser) Close() (err error) {
+	for _, c := range p {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
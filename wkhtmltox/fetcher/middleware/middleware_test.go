@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+type staticFetcher []byte
+
+func (p staticFetcher) FetchStream(ctx context.Context, params fetcher.FetchParams) (*fetcher.Document, error) {
+	return fetcher.NewDocument([]byte(p), fetcher.Metadata{ContentType: "text/plain"}), nil
+}
+
+func newMiddlewares(t *testing.T, conf string, names ...string) (middlewares []fetcher.Middleware) {
+	optionsConf := config.NewConfig(config.ConfigString(conf))
+
+	for _, name := range names {
+		m, err := fetcher.NewMiddleware(name, optionsConf.GetConfig(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		middlewares = append(middlewares, m)
+	}
+
+	return
+}
+
+func TestMiddlewareChain(t *testing.T) {
+
+	page := `<html><body onload="alert(1)"><script>alert(2)</script><iframe src="x"><p>x</p></iframe><p>hello</p></body></html>`
+
+	buf := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(page))
+	w.Close()
+
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	f := fetcher.Chain(staticFetcher(encoded), newMiddlewares(t, `
+		content-type {
+			allowed = ["text/*"]
+		}
+		max-size {
+			limit = 1024
+		}
+		sanitize {
+			elements = ["script", "iframe"]
+		}`,
+		"base64", "decompress", "content-type", "sanitize", "max-size")...)
+
+	doc, err := f.FetchStream(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := doc.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `<html><body><p>hello</p></body></html>` {
+		t.Errorf("unexpected document %s", data)
+	}
+}
+
+func TestMiddlewareReject(t *testing.T) {
+
+	f := fetcher.Chain(staticFetcher(strings.Repeat("a", 100)), newMiddlewares(t, `
+		max-size {
+			limit = 10
+		}`, "max-size")...)
+
+	_, err := f.FetchStream(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+
+	f = fetcher.Chain(staticFetcher("%PDF-1.4"), newMiddlewares(t, `
+		content-type {
+			allowed = ["text/html"]
+		}`, "content-type")...)
+
+	_, err = f.FetchStream(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("expected content type error, got %v", err)
+	}
+}
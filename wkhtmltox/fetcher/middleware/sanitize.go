@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/sanitize"
+)
+
+// NewSanitize strips elements, event handlers and urls from the html
+// document by the sanitize policy, options:
+//
+//	profile = basic # none, basic, strict
+//
+// and the other options of sanitize.NewPolicy, the renderer flags of the
+// policy could not be applied by middleware, use the sanitize option of
+// fetcher instead if it is required
+func NewSanitize(conf config.Configuration) (m fetcher.Middleware, err error) {
+
+	policy, err := sanitize.NewPolicy(conf, sanitize.ProfileBasic)
+	if err != nil {
+		err = fmt.Errorf("[middleware-sanitize]: %s", err.Error())
+		return
+	}
+
+	m = newTransformer(func(doc *fetcher.Document) (ret *fetcher.Document, err error) {
+
+		defer doc.Close()
+
+		buf := bytes.NewBuffer(nil)
+
+		err = policy.Sanitize(doc, buf)
+		if err != nil {
+			err = fmt.Errorf("[middleware-sanitize]: %s", err.Error())
+			return
+		}
+
+		meta := doc.Metadata
+		meta.LocalPath = ""
+
+		ret = fetcher.NewDocument(buf.Bytes(), meta)
+
+		return
+	})
+
+	return
+}
@@ -0,0 +1,130 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/gogap/config"
+)
+
+// StreamFetcher is the version 2 of Fetcher, the document is returned as a
+// reader with its metadata, and the fetch could be canceled by ctx
+type StreamFetcher interface {
+	FetchStream(ctx context.Context, params FetchParams) (*Document, error)
+}
+
+type Metadata struct {
+	ContentType string // MIME type of the document, e.g. text/html; charset=utf-8
+	SourceURL   string // Where the document comes from, used for resolving relative urls
+	Charset     string // Charset of the document if known
+	Size        int64  // Size in bytes, -1 means unknown
+	LocalPath   string // Path of the document if it is already on local disk
+}
+
+// Document is the fetched document, the caller must close it, the reader
+// must be readable even if LocalPath is set
+type Document struct {
+	Metadata
+	io.ReadCloser
+}
+
+func NewDocument(data []byte, meta Metadata) *Document {
+	if len(meta.ContentType) == 0 {
+		meta.ContentType = http.DetectContentType(data)
+	}
+
+	meta.Size = int64(len(data))
+
+	return NewStreamDocument(ioutil.NopCloser(bytes.NewReader(data)), meta)
+}
+
+// NewStreamDocument creates the document read from r, the Size of meta is
+// kept, it should be -1 while unknown
+func NewStreamDocument(r io.ReadCloser, meta Metadata) *Document {
+	if len(meta.Charset) == 0 {
+		if _, params, err := mime.ParseMediaType(meta.ContentType); err == nil {
+			meta.Charset = params["charset"]
+		}
+	}
+
+	return &Document{
+		Metadata:   meta,
+		ReadCloser: r,
+	}
+}
+
+// ReadAll reads the whole document and closes it
+func (p *Document) ReadAll() (data []byte, err error) {
+	defer p.Close()
+	return ioutil.ReadAll(p)
+}
+
+// NewStream creates a fetcher by driver name, drivers which only implement
+// Fetcher are adapted by ToStream
+func NewStream(name string, conf config.Configuration) (f StreamFetcher, err error) {
+	fetcher, err := New(name, conf)
+	if err != nil {
+		return
+	}
+
+	f = ToStream(fetcher)
+
+	return
+}
+
+// ToStream adapts a Fetcher to StreamFetcher, it returns the fetcher itself
+// if it already implements StreamFetcher
+func ToStream(f Fetcher) StreamFetcher {
+	if sf, ok := f.(StreamFetcher); ok {
+		return sf
+	}
+
+	return &streamAdapter{fetcher: f}
+}
+
+type streamAdapter struct {
+	fetcher Fetcher
+}
+
+func (p *streamAdapter) Fetch(params FetchParams) ([]byte, error) {
+	return p.fetcher.Fetch(params)
+}
+
+type fetchResult struct {
+	data []byte
+	err  error
+}
+
+func (p *streamAdapter) FetchStream(ctx context.Context, params FetchParams) (doc *Document, err error) {
+
+	ch := make(chan fetchResult, 1)
+
+	// the Fetch could not be canceled, its result is dropped while ctx is
+	// done first
+	go func() {
+		data, err := p.fetcher.Fetch(params)
+		select {
+		case ch <- fetchResult{data: data, err: err}:
+		case <-ctx.Done():
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case ret := <-ch:
+		if ret.err != nil {
+			err = ret.err
+			return
+		}
+
+		doc = NewDocument(ret.data, Metadata{})
+	}
+
+	return
+}
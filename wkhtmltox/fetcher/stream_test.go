@@ -0,0 +1,58 @@
+package fetcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type funcFetcher func(FetchParams) ([]byte, error)
+
+func (p funcFetcher) Fetch(params FetchParams) ([]byte, error) {
+	return p(params)
+}
+
+func TestToStream(t *testing.T) {
+
+	f := ToStream(funcFetcher(func(params FetchParams) ([]byte, error) {
+		return []byte("<html><body>hello</body></html>"), nil
+	}))
+
+	doc, err := f.FetchStream(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.ContentType != "text/html; charset=utf-8" || doc.Charset != "utf-8" || doc.Size != 31 {
+		t.Errorf("unexpected metadata %+v", doc.Metadata)
+	}
+
+	data, err := doc.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "<html><body>hello</body></html>" {
+		t.Errorf("unexpected document %q", data)
+	}
+
+	if sf := ToStream(funcFetcher(nil)); ToStream(sf.(Fetcher)) != sf {
+		t.Error("expected adapter not to be wrapped again")
+	}
+}
+
+func TestToStreamCanceled(t *testing.T) {
+
+	f := ToStream(funcFetcher(func(params FetchParams) ([]byte, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	_, err := f.FetchStream(ctx, nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
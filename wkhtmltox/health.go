@@ -0,0 +1,210 @@
+package wkhtmltox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+const canaryHTML = `<!DOCTYPE html><html><head><meta charset="utf-8"></head><body><p>go-wkhtmltox canary</p></body></html>`
+
+// Check is the result of a readiness check
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type health struct {
+	interval time.Duration
+	timeout  time.Duration
+
+	locker    sync.RWMutex
+	canaryAt  time.Time
+	canaryErr error
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newHealth reads the options of health checking:
+//
+//	canary-interval = 1m  # default is 0, the canary rendering is disabled
+//	canary-timeout  = 30s
+func newHealth(conf config.Configuration) *health {
+
+	h := &health{
+		timeout: time.Second * 30,
+		stop:    make(chan struct{}),
+	}
+
+	if conf != nil {
+		h.interval = conf.GetTimeDuration("canary-interval", h.interval)
+		h.timeout = conf.GetTimeDuration("canary-timeout", h.timeout)
+	}
+
+	return h
+}
+
+// close stops the canary
+func (p *health) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// canary renders the built-in page periodically, it waits in the queue as
+// the other jobs, until the health is closed
+func (p *WKHtmlToX) canary() {
+
+	run := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.health.timeout)
+		defer cancel()
+
+		// the canary is stopped while waiting as well
+		go func() {
+			select {
+			case <-p.health.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		release, err := p.queue.acquire(WithClient(ctx, "canary"))
+
+		if err == nil {
+			_, err = p.render(ctx, renderJob{
+				bin:         p.binaries.get("wkhtmltopdf"),
+				ext:         ".pdf",
+				inputMethod: "-",
+				input:       strings.NewReader(canaryHTML),
+			})
+
+			release()
+		}
+
+		p.health.locker.Lock()
+		p.health.canaryAt = time.Now()
+		p.health.canaryErr = err
+		p.health.locker.Unlock()
+	}
+
+	ticker := time.NewTicker(p.health.interval)
+	defer ticker.Stop()
+
+	for {
+		run()
+
+		select {
+		case <-ticker.C:
+		case <-p.health.stop:
+			return
+		}
+	}
+}
+
+// Ready checks the binaries, the latest canary rendering, the free space of
+// the working dir, the queue depth and the fetchers implementing
+// fetcher.HealthChecker
+func (p *WKHtmlToX) Ready(ctx context.Context) (ready bool, checks []Check) {
+
+	checks = append(checks, p.checkBinaries()...)
+
+	if p.health.interval > 0 {
+		checks = append(checks, p.checkCanary())
+	}
+
+	checks = append(checks, p.checkDisk(), p.checkQueue())
+
+	for name, hc := range p.healthCheckers {
+		check := Check{Name: "fetcher:" + name, OK: true}
+
+		if err := hc.Health(ctx); err != nil {
+			check.OK = false
+			check.Message = err.Error()
+		}
+
+		checks = append(checks, check)
+	}
+
+	ready = true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+
+	return
+}
+
+func (p *WKHtmlToX) checkBinaries() (checks []Check) {
+	for _, name := range []string{"wkhtmltopdf", "wkhtmltoimage"} {
+		bin := p.binaries.get(name)
+
+		check := Check{Name: "binary:" + name, OK: true}
+
+		if bin.options == nil {
+			check.Message = "not probed"
+		} else if _, err := os.Stat(bin.path); err != nil {
+			check.OK = false
+			check.Message = err.Error()
+		} else {
+			check.Message = bin.version
+		}
+
+		checks = append(checks, check)
+	}
+
+	return
+}
+
+func (p *WKHtmlToX) checkCanary() Check {
+
+	p.health.locker.RLock()
+	at, err := p.health.canaryAt, p.health.canaryErr
+	p.health.locker.RUnlock()
+
+	check := Check{Name: "canary"}
+
+	switch {
+	case at.IsZero():
+		check.Message = "pending"
+	case err != nil:
+		check.Message = err.Error()
+	case time.Since(at) > p.health.interval*3:
+		check.Message = fmt.Sprintf("stale, the latest rendering is at %s", at.Format(time.RFC3339))
+	default:
+		check.OK = true
+		check.Message = fmt.Sprintf("rendered at %s", at.Format(time.RFC3339))
+	}
+
+	return check
+}
+
+func (p *WKHtmlToX) checkDisk() Check {
+
+	check := Check{Name: "disk"}
+
+	free, percent, err := diskFree(p.workDir.root)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+
+	check.OK = free >= p.workDir.minFree && percent >= p.workDir.minFreePercent
+	check.Message = fmt.Sprintf("%d bytes (%.1f%%) free", free, percent)
+
+	return check
+}
+
+func (p *WKHtmlToX) checkQueue() Check {
+
+	stats := p.queue.stats()
+
+	return Check{
+		Name:    "queue",
+		OK:      stats.Waiting < stats.MaxWaiting,
+		Message: fmt.Sprintf("%d running, %d waiting, max waiting %d", stats.Running, stats.Waiting, stats.MaxWaiting),
+	}
+}
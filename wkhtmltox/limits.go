@@ -0,0 +1,155 @@
+package wkhtmltox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+)
+
+// limits bound the fetched document, the converted document and its pages,
+// 0 is unlimited
+type limits struct {
+	maxInputSize  int64
+	maxOutputSize int64
+	maxPages      int
+}
+
+// newLimits creates the limits, options:
+//
+//	max-input-size  = 10485760 # bytes of the fetched document
+//	max-output-size = 52428800 # bytes of the converted document, wkhtmltox is killed while the output grows past it
+//	max-pages       = 500      # pages of the converted pdf
+func newLimits(conf config.Configuration) limits {
+	if conf == nil {
+		return limits{}
+	}
+
+	return limits{
+		maxInputSize:  conf.GetInt64("max-input-size", 0),
+		maxOutputSize: conf.GetInt64("max-output-size", 0),
+		maxPages:      int(conf.GetInt32("max-pages", 0)),
+	}
+}
+
+type maxOutputSizeKey struct{}
+
+// WithMaxOutputSize limits the size of the converted document of the
+// conversions with ctx, e.g. by the permissions of the caller, 0 is unlimited,
+// the stricter one of it and the configured limit is applied
+func WithMaxOutputSize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, maxOutputSizeKey{}, size)
+}
+
+// outputSize returns the limit of the output of the conversion with ctx
+func (p limits) outputSize(ctx context.Context) int64 {
+	size, _ := ctx.Value(maxOutputSizeKey{}).(int64)
+
+	if size <= 0 || (p.maxOutputSize > 0 && p.maxOutputSize < size) {
+		return p.maxOutputSize
+	}
+
+	return size
+}
+
+// input checks the size of the document, the returned reader fails while
+// the streaming document exceeds the limit
+func (p limits) input(doc *fetcher.Document) (r *limitedReader, err error) {
+
+	r = &limitedReader{r: doc, limit: p.maxInputSize}
+
+	if p.maxInputSize <= 0 {
+		return
+	}
+
+	size := doc.Size
+
+	if len(doc.LocalPath) > 0 {
+		var fi os.FileInfo
+		if fi, err = os.Stat(doc.LocalPath); err == nil {
+			size = fi.Size()
+		}
+		err = nil
+	}
+
+	if size > p.maxInputSize {
+		err = errInputTooLarge(p.maxInputSize)
+	}
+
+	return
+}
+
+func errInputTooLarge(limit int64) error {
+	return newError(ErrInputTooLarge, fmt.Errorf("input size exceeds the limit %d", limit))
+}
+
+// limitedReader fails while more than limit bytes are read, it is unlimited
+// while limit is 0
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (p *limitedReader) Read(b []byte) (n int, err error) {
+	if p.limit <= 0 {
+		return p.r.Read(b)
+	}
+
+	if p.exceeded {
+		return 0, errInputTooLarge(p.limit)
+	}
+
+	// read one more byte to know whether the document exceeds the limit
+	if max := p.limit - p.read + 1; int64(len(b)) > max {
+		b = b[:max]
+	}
+
+	n, err = p.r.Read(b)
+	p.read += int64(n)
+
+	if p.read > p.limit {
+		n -= int(p.read - p.limit)
+		p.read = p.limit
+		p.exceeded = true
+		err = errInputTooLarge(p.limit)
+	}
+
+	return
+}
+
+// checkOutputSize returns ErrOutputTooLarge while the rendered file is larger
+// than the limit
+func checkOutputSize(filename string, limit int64) (err error) {
+	if limit <= 0 {
+		return
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return
+	}
+
+	if fi.Size() > limit {
+		err = errOutputTooLarge(limit)
+	}
+
+	return
+}
+
+func errOutputTooLarge(limit int64) error {
+	return newError(ErrOutputTooLarge, fmt.Errorf("output size exceeds the limit %d", limit))
+}
+
+// checkPages returns ErrTooManyPages while the converted pdf has more pages
+// than the limit
+func checkPages(pages, limit int) (err error) {
+	if limit > 0 && pages > limit {
+		err = newError(ErrTooManyPages, fmt.Errorf("pages %d exceed the limit %d", pages, limit))
+	}
+	return
+}
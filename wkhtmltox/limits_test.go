@@ -0,0 +1,56 @@
+package wkhtmltox
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitedReader(t *testing.T) {
+
+	for _, c := range []struct {
+		data     string
+		limit    int64
+		exceeded bool
+	}{
+		{"hello", 0, false},
+		{"hello", 5, false},
+		{"hello", 4, true},
+	} {
+		r := &limitedReader{r: strings.NewReader(c.data), limit: c.limit}
+
+		data, err := ioutil.ReadAll(r)
+
+		if r.exceeded != c.exceeded || (c.exceeded && ErrorKindOf(err) != ErrInputTooLarge) {
+			t.Errorf("read %q by limit %d: unexpected %v %v", c.data, c.limit, r.exceeded, err)
+		}
+
+		if !c.exceeded && string(data) != c.data {
+			t.Errorf("read %q by limit %d: got %q", c.data, c.limit, data)
+		}
+	}
+}
+
+func TestWatchOutput(t *testing.T) {
+
+	name := filepath.Join(t.TempDir(), "output.pdf")
+
+	done := make(chan struct{})
+	defer close(done)
+
+	exceeded := watchOutput(name, 4, done)
+
+	err := ioutil.WriteFile(name, []byte("%PDF-1.4"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-exceeded:
+	case <-time.After(time.Second):
+		t.Errorf("the output growing past the limit is not notified")
+	}
+
+}
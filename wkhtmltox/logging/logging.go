@@ -0,0 +1,96 @@
+// Package logging provides the structured logger of go-wkhtmltox, and carries
+// the request id and the per-request logger through the context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// HeaderRequestID is the header to accept, return and forward the request id
+	HeaderRequestID = "X-Request-ID"
+
+	// HeaderDebug opts in the debug logs of a single request
+	HeaderDebug = "X-Debug"
+
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	loggerKey
+)
+
+// New creates a logger with format of json or text, the level is one of
+// debug, info, warn and error
+func New(w io.Writer, format, level string) (logger *slog.Logger, err error) {
+
+	var lvl slog.Level
+
+	err = lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		err = fmt.Errorf("[logging]: level %s not support", level)
+		return
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case FormatJSON, "":
+		logger = slog.New(slog.NewJSONHandler(w, opts))
+	case FormatText:
+		logger = slog.New(slog.NewTextHandler(w, opts))
+	default:
+		err = fmt.Errorf("[logging]: format %s not support", format)
+	}
+
+	return
+}
+
+// WithRequestID returns a context carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request id of the context, or empty
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithLogger returns a context carrying the logger of the request
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger of the request, the default logger is
+// returned while the context has no logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ValidRequestID reports whether the id is safe to log and to forward, ids
+// from clients are limited to 128 printable ascii characters
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,110 @@
+package wkhtmltox
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	conversionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wkhtmltox",
+		Name:      "conversions_total",
+		Help:      "Count of the conversions by target, fetcher and error class.",
+	}, []string{"target", "fetcher", "error"})
+
+	fetchDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Name:      "fetch_duration_seconds",
+		Help:      "Latency of fetching the documents.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"fetcher"})
+
+	renderDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Name:      "render_duration_seconds",
+		Help:      "Latency of rendering by wkhtmltox.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"target"})
+
+	outputBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Name:      "output_bytes",
+		Help:      "Size of the converted documents.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"target"})
+
+	processesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wkhtmltox",
+		Name:      "processes_in_flight",
+		Help:      "Count of the running wkhtmltox processes.",
+	})
+
+	queueWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wkhtmltox",
+		Name:      "queue_waiting",
+		Help:      "Count of the conversions waiting in the queue.",
+	})
+
+	queueWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Name:      "queue_wait_seconds",
+		Help:      "Time of the conversions waiting in the queue.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"target"})
+
+	processPeakRSSBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wkhtmltox",
+		Name:      "process_peak_rss_bytes",
+		Help:      "Peak resident set size of the wkhtmltox processes.",
+		Buckets:   prometheus.ExponentialBuckets(16*1024*1024, 2, 8),
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		conversionsTotal,
+		fetchDurationSeconds,
+		renderDurationSeconds,
+		outputBytes,
+		processesInFlight,
+		queueWaiting,
+		queueWaitSeconds,
+		processPeakRSSBytes,
+	)
+}
+
+// targetOf returns pdf or image by the binary name
+func targetOf(name string) string {
+	return strings.TrimPrefix(name, "wkhtmlto")
+}
+
+// fetcherLabel limits the label values to the configured fetchers
+func (p *WKHtmlToX) fetcherLabel(name string) string {
+	switch {
+	case len(name) == 0 || name == "default":
+		return "default"
+	case p.HasFetcher(name):
+		return name
+	}
+	return "unknown"
+}
+
+func errorLabel(err error) string {
+	if err == nil {
+		return "none"
+	}
+	return string(ErrorKindOf(err))
+}
+
+// observePeakRSS observes the max rss of the exited process, it is in
+// kilobytes on linux
+func observePeakRSS(target string) func(*os.ProcessState) {
+	return func(state *os.ProcessState) {
+		if usage, ok := state.SysUsage().(*syscall.Rusage); ok && usage.Maxrss > 0 {
+			processPeakRSSBytes.WithLabelValues(target).Observe(float64(usage.Maxrss) * 1024)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package wkhtmltox
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+)
+
+// Progress is parsed from the console output of wkhtmltox, e.g.
+//
+//	Loading pages (1/6)
+//	[==============================>                             ] 50%
+//	Printing pages (6/6)
+//	[============================================================] Page 1 of 2
+//	Done
+type Progress struct {
+	Phase   string `json:"phase"`   // e.g. Loading pages
+	Step    int    `json:"step"`    // Step of the phase, starts with 1
+	Steps   int    `json:"steps"`   // Count of the phases
+	Percent int    `json:"percent"` // Percent of the phase
+	Page    int    `json:"page"`    // Printing page
+	Pages   int    `json:"pages"`   // Count of the printing pages
+	Done    bool   `json:"done"`
+}
+
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+// WithProgress returns the context reporting the converting progress to fn,
+// fn is called in the goroutine reading the console output of wkhtmltox
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+var (
+	phaseRegexp   = regexp.MustCompile(`^(\S.*?) \((\d+)/(\d+)\)$`)
+	barRegexp     = regexp.MustCompile(`^\[[=> ]*\]\s*(.*)$`)
+	percentRegexp = regexp.MustCompile(`^(\d+)%$`)
+	pageRegexp    = regexp.MustCompile(`^Page (\d+) of (\d+)$`)
+)
+
+// consoleWriter parses the progress lines of wkhtmltox's stderr, the other
+// lines are kept, at most the last limit bytes
+type consoleWriter struct {
+	onProgress ProgressFunc
+	progress   Progress
+
+	limit int
+	line  []byte
+	buf   bytes.Buffer
+}
+
+func newConsoleWriter(limit int, onProgress ProgressFunc) *consoleWriter {
+	return &consoleWriter{
+		limit:      limit,
+		onProgress: onProgress,
+	}
+}
+
+func (p *consoleWriter) Write(b []byte) (n int, err error) {
+
+	for _, c := range b {
+		if c == '\r' || c == '\n' {
+			p.flushLine()
+			continue
+		}
+
+		p.line = append(p.line, c)
+	}
+
+	return len(b), nil
+}
+
+// String returns the lines which are not progress
+func (p *consoleWriter) String() string {
+	p.flushLine()
+	return p.buf.String()
+}
+
+func (p *consoleWriter) flushLine() {
+
+	line := bytes.TrimSpace(p.line)
+	p.line = p.line[:0]
+
+	if len(line) == 0 {
+		return
+	}
+
+	if p.parseProgress(string(line)) {
+		if p.onProgress != nil {
+			p.onProgress(p.progress)
+		}
+		return
+	}
+
+	p.buf.Write(line)
+	p.buf.WriteByte('\n')
+
+	if p.limit > 0 && p.buf.Len() > p.limit {
+		tail := append([]byte(nil), p.buf.Bytes()[p.buf.Len()-p.limit:]...)
+		p.buf.Reset()
+		p.buf.Write(tail)
+	}
+}
+
+func (p *consoleWriter) parseProgress(line string) bool {
+
+	if line == "Done" {
+		p.progress.Percent = 100
+		p.progress.Done = true
+		return true
+	}
+
+	if m := phaseRegexp.FindStringSubmatch(line); m != nil {
+		p.progress.Phase = m[1]
+		p.progress.Step, _ = strconv.Atoi(m[2])
+		p.progress.Steps, _ = strconv.Atoi(m[3])
+		p.progress.Percent = 0
+		return true
+	}
+
+	m := barRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+
+	if pm := percentRegexp.FindStringSubmatch(m[1]); pm != nil {
+		p.progress.Percent, _ = strconv.Atoi(pm[1])
+	} else if pm := pageRegexp.FindStringSubmatch(m[1]); pm != nil {
+		p.progress.Page, _ = strconv.Atoi(pm[1])
+		p.progress.Pages, _ = strconv.Atoi(pm[2])
+		if p.progress.Pages > 0 {
+			p.progress.Percent = p.progress.Page * 100 / p.progress.Pages
+		}
+	}
+
+	return true
+}
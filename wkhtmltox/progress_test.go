@@ -0,0 +1,39 @@
+package wkhtmltox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConsoleWriterProgress(t *testing.T) {
+
+	var events []Progress
+
+	w := newConsoleWriter(16, func(p Progress) {
+		events = append(events, p)
+	})
+
+	w.Write([]byte("Loading pages (1/6)\n[>                    ] 0%\r[==========>         ] 50"))
+	w.Write([]byte("%\rWarning: Failed to load file:///etc/passwd (ignore)\n"))
+	w.Write([]byte("Printing pages (6/6)\n[====================] Page 1 of 2\r[====================] Page 2 of 2\nDone\n"))
+
+	if len(events) != 7 {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	if p := events[2]; p.Phase != "Loading pages" || p.Step != 1 || p.Steps != 6 || p.Percent != 50 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	if p := events[4]; p.Phase != "Printing pages" || p.Page != 1 || p.Pages != 2 || p.Percent != 50 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	if p := events[6]; !p.Done || p.Percent != 100 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	if s := w.String(); len(s) != 16 || !strings.HasSuffix(s, "(ignore)\n") {
+		t.Errorf("unexpected console output %q", s)
+	}
+}
@@ -0,0 +1,221 @@
+package wkhtmltox
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/gogap/config"
+)
+
+type clientKey struct{}
+
+// WithClient marks the conversions of ctx as the jobs of the client, e.g. the
+// api key or the ip of the caller, the free slots are handed to the waiting
+// clients in turn, so a client with many jobs could not starve the others
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// queue bounds the count of the running wkhtmltox processes, and the count
+// of the jobs waiting for them, the waiting jobs are scheduled by round robin
+// of the clients
+type queue struct {
+	locker sync.Mutex
+
+	concurrency         int64
+	maxWaiting          int64
+	maxWaitingPerClient int64
+
+	running int64
+	waiting int64
+
+	waiters map[string]*list.List // the waiting jobs of every client
+	clients []string              // the clients having waiting jobs
+	next    int                   // the client to be scheduled next
+}
+
+type waiter struct {
+	client string
+	ready  chan struct{}
+	elem   *list.Element
+}
+
+// newQueue creates the queue, options:
+//
+//	concurrency            = 4   # default is the count of cpus
+//	max-waiting            = 100 # the new jobs are refused while the waiting jobs reach it
+//	max-waiting-per-client = 0   # the new jobs of a client are refused while its waiting jobs reach it, 0 is unlimited
+func newQueue(conf config.Configuration) *queue {
+
+	concurrency := int64(runtime.NumCPU())
+	maxWaiting := int64(100)
+	maxWaitingPerClient := int64(0)
+
+	if conf != nil {
+		concurrency = conf.GetInt64("concurrency", concurrency)
+		maxWaiting = conf.GetInt64("max-waiting", maxWaiting)
+		maxWaitingPerClient = conf.GetInt64("max-waiting-per-client", maxWaitingPerClient)
+	}
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &queue{
+		concurrency:         concurrency,
+		maxWaiting:          maxWaiting,
+		maxWaitingPerClient: maxWaitingPerClient,
+		waiters:             map[string]*list.List{},
+	}
+}
+
+// acquire waits for a slot, the release must be called after the job finished
+func (p *queue) acquire(ctx context.Context) (release func(), err error) {
+
+	client := clientFromContext(ctx)
+
+	p.locker.Lock()
+
+	if p.running < p.concurrency && p.waiting == 0 {
+		p.running++
+		p.locker.Unlock()
+		return p.release, nil
+	}
+
+	if p.waiting >= p.maxWaiting {
+		p.locker.Unlock()
+		err = newError(ErrQueueFull, fmt.Errorf("queue is full, %d jobs are waiting", p.maxWaiting))
+		return
+	}
+
+	jobs := p.waiters[client]
+
+	if p.maxWaitingPerClient > 0 && jobs != nil && int64(jobs.Len()) >= p.maxWaitingPerClient {
+		p.locker.Unlock()
+		err = newError(ErrQueueFull, fmt.Errorf("queue is full, %d jobs of the client are waiting", p.maxWaitingPerClient))
+		return
+	}
+
+	if jobs == nil {
+		jobs = list.New()
+		p.waiters[client] = jobs
+		p.clients = append(p.clients, client)
+	}
+
+	w := &waiter{client: client, ready: make(chan struct{})}
+	w.elem = jobs.PushBack(w)
+	p.waiting++
+
+	p.locker.Unlock()
+
+	queueWaiting.Inc()
+	defer queueWaiting.Dec()
+
+	select {
+	case <-w.ready:
+		return p.release, nil
+	case <-ctx.Done():
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	select {
+	case <-w.ready:
+		// the slot is handed over while canceling, pass it to the next
+		p.running--
+		p.dispatch()
+	default:
+		p.remove(w)
+	}
+
+	err = classify(ErrCanceled, ctx.Err())
+
+	return
+}
+
+func (p *queue) release() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	p.running--
+	p.dispatch()
+}
+
+// dispatch hands the free slots to the waiting jobs, one job of a client in
+// turn, the locker must be held
+func (p *queue) dispatch() {
+	for p.running < p.concurrency && p.waiting > 0 {
+		if p.next >= len(p.clients) {
+			p.next = 0
+		}
+
+		client := p.clients[p.next]
+		w := p.waiters[client].Front().Value.(*waiter)
+
+		p.remove(w)
+		p.running++
+
+		// the client is scheduled after the others, the removed client is
+		// replaced by the next one already
+		if _, exist := p.waiters[client]; exist {
+			p.next++
+		}
+
+		close(w.ready)
+	}
+}
+
+// remove removes the waiting job, and the client without waiting jobs, the
+// locker must be held
+func (p *queue) remove(w *waiter) {
+
+	jobs := p.waiters[w.client]
+	jobs.Remove(w.elem)
+	p.waiting--
+
+	if jobs.Len() > 0 {
+		return
+	}
+
+	i := 0
+	for ; i < len(p.clients) && p.clients[i] != w.client; i++ {
+	}
+
+	delete(p.waiters, w.client)
+	p.clients = append(p.clients[:i], p.clients[i+1:]...)
+
+	if i < p.next {
+		p.next--
+	}
+}
+
+// QueueStats is the snapshot of the converting queue
+type QueueStats struct {
+	Waiting     int64 `json:"waiting"`
+	Running     int64 `json:"running"`
+	Concurrency int64 `json:"concurrency"`
+	MaxWaiting  int64 `json:"max_waiting"`
+	Clients     int64 `json:"clients"` // The clients having waiting jobs
+}
+
+func (p *queue) stats() QueueStats {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return QueueStats{
+		Waiting:     p.waiting,
+		Running:     p.running,
+		Concurrency: p.concurrency,
+		MaxWaiting:  p.maxWaiting,
+		Clients:     int64(len(p.clients)),
+	}
+}
@@ -0,0 +1,94 @@
+package wkhtmltox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+func TestQueue(t *testing.T) {
+
+	q := newQueue(config.NewConfig(config.ConfigString(`
+		concurrency = 1
+		max-waiting = 1`)))
+
+	release, err := q.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	waited := make(chan error)
+	go func() {
+		_, err := q.acquire(ctx)
+		waited <- err
+	}()
+
+	for q.stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err = q.acquire(context.Background()); ErrorKindOf(err) != ErrQueueFull {
+		t.Errorf("expected queue full, got %v", err)
+	}
+
+	if err = <-waited; ErrorKindOf(err) != ErrTimeout {
+		t.Errorf("expected timeout while waiting, got %v", err)
+	}
+
+	release()
+
+	if stats := q.stats(); stats.Running != 0 || stats.Waiting != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQueueFairness(t *testing.T) {
+
+	q := newQueue(config.NewConfig(config.ConfigString(`
+		concurrency = 1
+		max-waiting = 10
+		max-waiting-per-client = 3`)))
+
+	release, err := q.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 4)
+
+	for i, client := range []string{"a", "a", "a", "b"} {
+		go func(client string) {
+			r, err := q.acquire(WithClient(context.Background(), client))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- client
+			r()
+		}(client)
+
+		for q.stats().Waiting != int64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if _, err = q.acquire(WithClient(context.Background(), "a")); ErrorKindOf(err) != ErrQueueFull {
+		t.Errorf("expected queue full of the client, got %v", err)
+	}
+
+	release()
+
+	got := ""
+	for i := 0; i < 4; i++ {
+		got += <-order
+	}
+
+	if got != "abaa" {
+		t.Errorf("expected the clients are scheduled in turn, got %s", got)
+	}
+}
@@ -0,0 +1,149 @@
+package wkhtmltox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	ErrorPolicyStrict             = "strict"               // Any non-zero exit code is failure
+	ErrorPolicyTolerateLoadErrors = "tolerate-load-errors" // Exit code 1 with an output is success with warnings
+	ErrorPolicyIgnoreErrors       = "ignore-errors"        // Any non-zero exit code with an output is success with warnings
+)
+
+// the flags set to ignore by the error policy unless the user gave them
+var loadErrorFlags = []string{"--load-error-handling", "--load-media-error-handling"}
+
+var warningPrefixes = []string{"Warning:", "Error:", "Exit with code"}
+
+var (
+	// /Type /Pages is the page tree, the page may end the data
+	pdfPageRegexp = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// Result is the converted document and its metadata
+type Result struct {
+	Data           []byte
+	Warnings       []string      // e.g. the resources failed to load
+	ContentType    string        // e.g. application/pdf, image/png
+	Size           int           // Size of Data
+	Width          int           // Width of the image, 0 for pdf
+	Height         int           // Height of the image, 0 for pdf
+	Pages          int           // Page count of the pdf, 0 for image
+	FetchDuration  time.Duration // 0 while converting from uri
+	RenderDuration time.Duration
+	Version        string // Version of wkhtmltox, e.g. 0.12.6 (with patched qt)
+}
+
+// parseMetadata fills the content type, size, dimensions and page count by
+// the data
+func (p *Result) parseMetadata(ext string) {
+
+	p.Size = len(p.Data)
+
+	p.ContentType = mime.TypeByExtension(ext)
+	if len(p.ContentType) == 0 {
+		p.ContentType = http.DetectContentType(p.Data)
+	}
+
+	if ext == ".pdf" {
+		p.Pages = len(pdfPageRegexp.FindAll(p.Data, -1))
+		return
+	}
+
+	if conf, _, err := image.DecodeConfig(bytes.NewReader(p.Data)); err == nil {
+		p.Width = conf.Width
+		p.Height = conf.Height
+	}
+}
+
+// parseVersion parses the output of --version, e.g. wkhtmltopdf 0.12.6 (with patched qt)
+func parseVersion(name string, output []byte) string {
+	version := strings.TrimSpace(string(output))
+	return strings.TrimSpace(strings.TrimPrefix(version, name))
+}
+
+func checkErrorPolicy(policy string) (err error) {
+	switch policy {
+	case ErrorPolicyStrict, ErrorPolicyTolerateLoadErrors, ErrorPolicyIgnoreErrors:
+	default:
+		err = fmt.Errorf("error policy of %s not support", policy)
+	}
+	return
+}
+
+// errorPolicyArgs returns the args asking wkhtmltox to continue while the
+// page or the media failed to load, the handlings given by the user are kept
+func errorPolicyArgs(policy string, args []string) []string {
+	if policy == ErrorPolicyStrict {
+		return args
+	}
+
+	for _, flag := range loadErrorFlags {
+		if !hasArg(args, flag) {
+			args = append(args, flag, "ignore")
+		}
+	}
+
+	return args
+}
+
+func hasArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// tolerate reports whether the failure of wkhtmltox is acceptable by the
+// policy, the warnings are parsed from its stderr
+func tolerate(policy string, err error) (warnings []string, ok bool) {
+
+	var e *Error
+	if !errors.As(err, &e) || e.ExitCode <= 0 {
+		return
+	}
+
+	switch policy {
+	case ErrorPolicyTolerateLoadErrors:
+		ok = e.ExitCode == 1
+	case ErrorPolicyIgnoreErrors:
+		ok = true
+	}
+
+	if !ok {
+		return
+	}
+
+	warnings = parseWarnings(e.Stderr)
+
+	return
+}
+
+// parseWarnings returns the lines of the console output reporting the
+// resources failed to load
+func parseWarnings(stderr string) (warnings []string) {
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range warningPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				warnings = append(warnings, line)
+				break
+			}
+		}
+	}
+
+	return
+}
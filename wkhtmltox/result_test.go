@@ -0,0 +1,26 @@
+package wkhtmltox
+
+import (
+	"testing"
+)
+
+func TestParseMetadataPages(t *testing.T) {
+
+	cases := []struct {
+		data  string
+		pages int
+	}{
+		{"1 0 obj << /Type /Pages /Count 2 >>\n2 0 obj << /Type /Page >>\n3 0 obj << /Type/Page/Parent 1 0 R >>", 2},
+		{"1 0 obj << /Type /Pages >>\n2 0 obj << /Type /Page", 1},
+		{"<< /Type /PageLabel >>", 0},
+	}
+
+	for _, c := range cases {
+		result := &Result{Data: []byte("%PDF-1.4\n" + c.data)}
+		result.parseMetadata(".pdf")
+
+		if result.Pages != c.pages {
+			t.Errorf("expected %d pages of %q, got %d", c.pages, c.data, result.Pages)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package wkhtmltox
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+const (
+	NamespaceMount   = "mount"   // Only the bind paths and the working dir are visible
+	NamespaceNetwork = "network" // No network, the uri converting could not work
+	NamespacePID     = "pid"     // The converter could not see the other processes
+)
+
+var defaultBindPaths = []string{
+	"/usr", "/bin", "/lib", "/lib64", "/etc/fonts", "/etc/ssl", "/etc/ca-certificates",
+	"/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf",
+}
+
+// sandbox restricts the resources of the converter process, rlimits are
+// applied by prlimit and namespaces by bwrap
+type sandbox struct {
+	addressSpace int64
+	cpuTime      time.Duration
+	fileSize     int64
+	nproc        int64
+	uid          int64
+	gid          int64
+	namespaces   map[string]bool
+	bindPaths    []string
+
+	prlimit string
+	bwrap   string
+}
+
+// newSandbox returns nil while the sandbox is not enabled, options:
+//
+//	enabled       = true
+//	address-space = 2147483648 # bytes
+//	cpu-time      = 120s
+//	file-size     = 104857600  # bytes
+//	nproc         = 256
+//	uid           = 65534
+//	gid           = 65534
+//	namespaces    = ["mount", "network", "pid"]
+//	bind-paths    = ["/usr", "/lib"]
+func newSandbox(conf config.Configuration) (sb *sandbox, err error) {
+
+	if conf == nil || !conf.GetBoolean("enabled", false) {
+		return
+	}
+
+	s := &sandbox{
+		addressSpace: conf.GetInt64("address-space", 0),
+		cpuTime:      conf.GetTimeDuration("cpu-time", 0),
+		fileSize:     conf.GetInt64("file-size", 0),
+		nproc:        conf.GetInt64("nproc", 0),
+		uid:          conf.GetInt64("uid", -1),
+		gid:          conf.GetInt64("gid", -1),
+		namespaces:   make(map[string]bool),
+		bindPaths:    conf.GetStringList("bind-paths"),
+		prlimit:      conf.GetString("prlimit", "prlimit"),
+		bwrap:        conf.GetString("bwrap", "bwrap"),
+	}
+
+	if (s.uid < 0) != (s.gid < 0) {
+		err = fmt.Errorf("[sandbox]: uid and gid should be set together")
+		return
+	}
+
+	for _, ns := range conf.GetStringList("namespaces") {
+		switch ns {
+		case NamespaceMount, NamespaceNetwork, NamespacePID:
+			s.namespaces[ns] = true
+		default:
+			err = fmt.Errorf("[sandbox]: namespace %s not support", ns)
+			return
+		}
+	}
+
+	if len(s.bindPaths) == 0 {
+		s.bindPaths = defaultBindPaths
+	}
+
+	err = s.prepare()
+	if err != nil {
+		err = fmt.Errorf("[sandbox]: %s", err.Error())
+		return
+	}
+
+	sb = s
+
+	return
+}
+
+func (p *sandbox) hasLimits() bool {
+	return p.addressSpace > 0 || p.cpuTime > 0 || p.fileSize > 0 || p.nproc > 0
+}
+
+// wrapArgs returns the command line running name in dir with the limits and
+// namespaces
+func (p *sandbox) wrapArgs(dir, name string, args ...string) (argv []string) {
+
+	argv = append([]string{name}, args...)
+
+	if p.hasLimits() {
+		limits := []string{p.prlimit}
+
+		if p.addressSpace > 0 {
+			limits = append(limits, "--as="+strconv.FormatInt(p.addressSpace, 10))
+		}
+
+		if p.cpuTime > 0 {
+			limits = append(limits, "--cpu="+strconv.FormatInt(int64((p.cpuTime+time.Second-1)/time.Second), 10))
+		}
+
+		if p.fileSize > 0 {
+			limits = append(limits, "--fsize="+strconv.FormatInt(p.fileSize, 10))
+		}
+
+		if p.nproc > 0 {
+			limits = append(limits, "--nproc="+strconv.FormatInt(p.nproc, 10))
+		}
+
+		argv = append(append(limits, "--"), argv...)
+	}
+
+	if len(p.namespaces) == 0 {
+		return
+	}
+
+	wrap := []string{p.bwrap, "--die-with-parent"}
+
+	if p.namespaces[NamespaceMount] {
+		for _, path := range p.bindPaths {
+			wrap = append(wrap, "--ro-bind-try", path, path)
+		}
+		wrap = append(wrap, "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp")
+	} else {
+		wrap = append(wrap, "--bind", "/", "/", "--dev", "/dev", "--proc", "/proc")
+	}
+
+	wrap = append(wrap, "--bind", dir, dir, "--chdir", dir, "--setenv", "HOME", dir, "--setenv", "TMPDIR", dir)
+
+	if p.namespaces[NamespaceNetwork] {
+		wrap = append(wrap, "--unshare-net")
+	}
+
+	if p.namespaces[NamespacePID] {
+		wrap = append(wrap, "--unshare-pid")
+	}
+
+	argv = append(append(wrap, "--"), argv...)
+
+	return
+}
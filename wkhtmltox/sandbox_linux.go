@@ -0,0 +1,83 @@
+//go:build linux
+// +build linux
+
+package wkhtmltox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// prepare finds the external tools, the sandbox fails at startup rather than
+// at the first converting while they are missing
+func (p *sandbox) prepare() (err error) {
+
+	if p.hasLimits() {
+		p.prlimit, err = lookTool(p.prlimit, "prlimit", "the rlimits", "util-linux")
+		if err != nil {
+			return
+		}
+	}
+
+	if len(p.namespaces) > 0 {
+		p.bwrap, err = lookTool(p.bwrap, "bwrap", "the namespaces", "bubblewrap")
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func lookTool(path, option, usage, pkg string) (string, error) {
+	found, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("%s is required by %s, install %s or set sandbox.%s, %s", path, usage, pkg, option, err.Error())
+	}
+	return found, nil
+}
+
+// command creates the command running in the sandbox, dir is the private
+// working dir of the command, the output should be written into it
+func (p *sandbox) command(dir, name string, args ...string) (cmd *exec.Cmd, err error) {
+
+	name, err = exec.LookPath(name)
+	if err != nil {
+		return
+	}
+
+	argv := p.wrapArgs(dir, name, args...)
+
+	cmd = exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+	}
+
+	if lang := os.Getenv("LANG"); len(lang) > 0 {
+		cmd.Env = append(cmd.Env, "LANG="+lang)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if p.uid >= 0 {
+		err = os.Chown(dir, int(p.uid), int(p.gid))
+		if err != nil {
+			return
+		}
+
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid: uint32(p.uid),
+			Gid: uint32(p.gid),
+		}
+	}
+
+	return
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package wkhtmltox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errSandboxNotSupport = errors.New("sandbox is only supported on linux")
+
+func (p *sandbox) prepare() (err error) {
+	return errSandboxNotSupport
+}
+
+func (p *sandbox) command(dir, name string, args ...string) (cmd *exec.Cmd, err error) {
+	err = errSandboxNotSupport
+	return
+}
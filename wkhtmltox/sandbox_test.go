@@ -0,0 +1,48 @@
+package wkhtmltox
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+func TestSandboxArgs(t *testing.T) {
+
+	sb := &sandbox{
+		addressSpace: 1024,
+		cpuTime:      time.Millisecond * 1500,
+		namespaces:   map[string]bool{NamespaceMount: true, NamespaceNetwork: true},
+		bindPaths:    []string{"/usr"},
+		prlimit:      "prlimit",
+		bwrap:        "bwrap",
+	}
+
+	argv := strings.Join(sb.wrapArgs("/tmp/job", "wkhtmltopdf", "-", "out.pdf"), " ")
+
+	expect := "bwrap --die-with-parent --ro-bind-try /usr /usr --dev /dev --proc /proc --tmpfs /tmp " +
+		"--bind /tmp/job /tmp/job --chdir /tmp/job --setenv HOME /tmp/job --setenv TMPDIR /tmp/job --unshare-net -- " +
+		"prlimit --as=1024 --cpu=2 -- wkhtmltopdf - out.pdf"
+
+	if argv != expect {
+		t.Errorf("unexpected sandbox args %s", argv)
+	}
+}
+
+func TestSandboxMissingTool(t *testing.T) {
+
+	if runtime.GOOS != "linux" {
+		t.Skip("sandbox is only supported on linux")
+	}
+
+	_, err := newSandbox(config.NewConfig(config.ConfigString(`
+		enabled  = true
+		cpu-time = 10s
+		prlimit  = "/nonexistent/prlimit"`)))
+
+	if err == nil || !strings.Contains(err.Error(), "install util-linux or set sandbox.prlimit") {
+		t.Errorf("expected clear error of the missing prlimit, got %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gogap/config"
+	"golang.org/x/net/html"
+)
+
+const (
+	ProfileNone   = "none"   // Keep the document as it is
+	ProfileBasic  = "basic"  // Strip scripts, event handlers and javascript/file urls
+	ProfileStrict = "strict" // Basic, and strip frames, objects, embeds, forms and external urls
+)
+
+var (
+	profileLevels = map[string]int{
+		ProfileNone:   0,
+		ProfileBasic:  1,
+		ProfileStrict: 2,
+	}
+
+	voidElements = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true,
+		"hr": true, "img": true, "input": true, "link": true, "meta": true,
+		"param": true, "source": true, "track": true, "wbr": true,
+	}
+
+	urlAttrs = map[string]bool{
+		"href": true, "src": true, "action": true, "formaction": true, "poster": true,
+		"background": true, "data": true, "codebase": true, "cite": true,
+		"xlink:href": true, "manifest": true, "longdesc": true, "usemap": true,
+	}
+
+	cssURLRegexp = regexp.MustCompile(`(?i)url\(\s*(['"]?)([^'")]*)(['"]?)\s*\)|@import\s+(['"])([^'"]*)(['"])`)
+)
+
+// Policy describes what should be stripped from the document and how the
+// renderer should be restricted
+type Policy struct {
+	Name               string
+	Level              int             // Level of the built-in profile, a weaker policy could not override a stronger one
+	Elements           map[string]bool // Elements stripped with their content
+	EventHandlers      bool            // Strip on* attributes
+	ForbidScriptURLs   bool            // Strip javascript: and vbscript: urls
+	ForbidFileURLs     bool            // Strip file: urls
+	ForbidExternalURLs bool            // Strip absolute urls except data:, and relative urls which could be resolved by <base>
+	AllowedHosts       map[string]bool // Hosts allowed while ForbidExternalURLs
+
+	DisableJavaScript      bool // Run the renderer with --disable-javascript
+	DisableLocalFileAccess bool // Run the renderer with --disable-local-file-access
+}
+
+// Profile returns the built-in policy by name
+func Profile(name string) (policy *Policy, err error) {
+
+	switch name {
+	case ProfileNone, "":
+		policy = &Policy{Name: ProfileNone}
+	case ProfileBasic:
+		policy = &Policy{
+			Name:                   ProfileBasic,
+			Level:                  profileLevels[ProfileBasic],
+			Elements:               toSet("script", "noscript"),
+			EventHandlers:          true,
+			ForbidScriptURLs:       true,
+			ForbidFileURLs:         true,
+			DisableJavaScript:      true,
+			DisableLocalFileAccess: true,
+		}
+	case ProfileStrict:
+		policy = &Policy{
+			Name:                   ProfileStrict,
+			Level:                  profileLevels[ProfileStrict],
+			Elements:               toSet("script", "noscript", "iframe", "frame", "frameset", "object", "embed", "applet", "base", "form", "portal"),
+			EventHandlers:          true,
+			ForbidScriptURLs:       true,
+			ForbidFileURLs:         true,
+			ForbidExternalURLs:     true,
+			DisableJavaScript:      true,
+			DisableLocalFileAccess: true,
+		}
+	default:
+		err = fmt.Errorf("sanitize profile of %s not exist", name)
+	}
+
+	return
+}
+
+// NewPolicy creates the policy from the profile in conf (or defaultProfile),
+// and overrides it by the other options:
+//
+//	profile              = basic
+//	elements             = ["script", "iframe"]
+//	event-handlers       = true
+//	forbid-file-urls     = true
+//	forbid-external-urls = false
+//	allowed-hosts        = ["cdn.example.com"]
+func NewPolicy(conf config.Configuration, defaultProfile string) (policy *Policy, err error) {
+
+	if conf == nil {
+		return Profile(defaultProfile)
+	}
+
+	policy, err = Profile(conf.GetString("profile", defaultProfile))
+	if err != nil {
+		return
+	}
+
+	if elements := conf.GetStringList("elements"); len(elements) > 0 {
+		policy.Elements = toSet(elements...)
+	}
+
+	policy.EventHandlers = conf.GetBoolean("event-handlers", policy.EventHandlers)
+	policy.ForbidScriptURLs = conf.GetBoolean("forbid-script-urls", policy.ForbidScriptURLs)
+	policy.ForbidFileURLs = conf.GetBoolean("forbid-file-urls", policy.ForbidFileURLs)
+	policy.ForbidExternalURLs = conf.GetBoolean("forbid-external-urls", policy.ForbidExternalURLs)
+	policy.DisableJavaScript = conf.GetBoolean("disable-javascript", policy.DisableJavaScript)
+	policy.DisableLocalFileAccess = conf.GetBoolean("disable-local-file-access", policy.DisableLocalFileAccess)
+
+	if hosts := conf.GetStringList("allowed-hosts"); len(hosts) > 0 {
+		policy.AllowedHosts = toSet(hosts...)
+	}
+
+	return
+}
+
+// Enabled reports whether the policy changes anything
+func (p *Policy) Enabled() bool {
+	return p != nil && (len(p.Elements) > 0 || p.EventHandlers || p.ForbidScriptURLs ||
+		p.ForbidFileURLs || p.ForbidExternalURLs || p.DisableJavaScript || p.DisableLocalFileAccess)
+}
+
+// Sanitize reads html from r and writes the sanitized html to w
+func (p *Policy) Sanitize(r io.Reader, w io.Writer) (err error) {
+
+	z := html.NewTokenizer(r)
+
+	skipTag := ""
+	skipDepth := 0
+	inStyle := false
+
+	for {
+		tt := z.Next()
+
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			{
+				tok := z.Token()
+
+				if skipDepth > 0 {
+					if tt == html.StartTagToken && tok.Data == skipTag {
+						skipDepth++
+					}
+					continue
+				}
+
+				if p.Elements[tok.Data] {
+					if tt == html.StartTagToken && !voidElements[tok.Data] {
+						skipTag = tok.Data
+						skipDepth = 1
+					}
+					continue
+				}
+
+				if tok.Data == "meta" && p.forbidMetaRefresh(tok) {
+					continue
+				}
+
+				tok.Attr = p.sanitizeAttrs(tok.Attr)
+
+				inStyle = tt == html.StartTagToken && tok.Data == "style"
+
+				_, err = io.WriteString(w, tok.String())
+			}
+		case html.EndTagToken:
+			{
+				name, _ := z.TagName()
+
+				if skipDepth > 0 {
+					if string(name) == skipTag {
+						skipDepth--
+					}
+					continue
+				}
+
+				if p.Elements[string(name)] {
+					continue
+				}
+
+				inStyle = false
+
+				_, err = w.Write(z.Raw())
+			}
+		case html.TextToken:
+			{
+				if skipDepth > 0 {
+					continue
+				}
+
+				raw := z.Raw()
+
+				if inStyle {
+					raw = []byte(p.sanitizeCSS(string(raw)))
+				}
+
+				_, err = w.Write(raw)
+			}
+		default:
+			{
+				if skipDepth > 0 {
+					continue
+				}
+
+				_, err = w.Write(z.Raw())
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SanitizeBytes is the same as Sanitize for the document in memory
+func (p *Policy) SanitizeBytes(data []byte) (ret []byte, err error) {
+	buf := bytes.NewBuffer(nil)
+
+	err = p.Sanitize(bytes.NewReader(data), buf)
+	if err != nil {
+		return
+	}
+
+	ret = buf.Bytes()
+
+	return
+}
+
+// RendererArgs returns the command args of wkhtmltox enforcing the policy
+func (p *Policy) RendererArgs() (args []string) {
+	if p == nil {
+		return
+	}
+
+	if p.DisableJavaScript {
+		args = append(args, "--disable-javascript")
+	}
+
+	if p.DisableLocalFileAccess {
+		args = append(args, "--disable-local-file-access")
+	}
+
+	return
+}
+
+func (p *Policy) sanitizeAttrs(attrs []html.Attribute) []html.Attribute {
+	ret := attrs[:0]
+
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+
+		if len(attr.Namespace) > 0 {
+			key = strings.ToLower(attr.Namespace) + ":" + key
+		}
+
+		if p.EventHandlers && strings.HasPrefix(key, "on") {
+			continue
+		}
+
+		if urlAttrs[key] && !p.allowURL(attr.Val) {
+			continue
+		}
+
+		if key == "srcset" {
+			if !p.allowSrcset(attr.Val) {
+				continue
+			}
+		}
+
+		if key == "style" {
+			attr.Val = p.sanitizeCSS(attr.Val)
+		}
+
+		ret = append(ret, attr)
+	}
+
+	return ret
+}
+
+func (p *Policy) forbidMetaRefresh(tok html.Token) bool {
+	for _, attr := range tok.Attr {
+		if strings.EqualFold(attr.Key, "http-equiv") && strings.EqualFold(strings.TrimSpace(attr.Val), "refresh") {
+			for _, a := range tok.Attr {
+				if strings.EqualFold(a.Key, "content") {
+					idx := strings.Index(strings.ToLower(a.Val), "url=")
+					if idx >= 0 && !p.allowURL(strings.Trim(a.Val[idx+4:], `'" `)) {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) sanitizeCSS(css string) string {
+	if !p.ForbidFileURLs && !p.ForbidExternalURLs && !p.ForbidScriptURLs {
+		return css
+	}
+
+	return cssURLRegexp.ReplaceAllStringFunc(css, func(match string) string {
+		sub := cssURLRegexp.FindStringSubmatch(match)
+
+		ref := sub[2]
+		if len(sub[5]) > 0 {
+			ref = sub[5]
+		}
+
+		if p.allowURL(ref) {
+			return match
+		}
+
+		if strings.HasPrefix(strings.ToLower(match), "@import") {
+			return ""
+		}
+
+		return "url()"
+	})
+}
+
+func (p *Policy) allowSrcset(srcset string) bool {
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 && !p.allowURL(fields[0]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Policy) allowURL(ref string) bool {
+
+	// browsers ignore the control characters and spaces in scheme
+	ref = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, ref)
+
+	if len(ref) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+
+	switch scheme {
+	case "javascript", "vbscript":
+		return !p.ForbidScriptURLs
+	case "file":
+		return !p.ForbidFileURLs && !p.ForbidExternalURLs
+	case "data", "":
+		if scheme == "" && len(u.Host) > 0 {
+			// protocol relative url, e.g. //example.com/a.png
+			return !p.ForbidExternalURLs || p.AllowedHosts[strings.ToLower(u.Hostname())]
+		}
+		return true
+	}
+
+	if !p.ForbidExternalURLs {
+		return true
+	}
+
+	return p.AllowedHosts[strings.ToLower(u.Hostname())]
+}
+
+func toSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+	return set
+}
@@ -0,0 +1,65 @@
+package sanitize
+
+import (
+	"testing"
+
+	"github.com/gogap/config"
+)
+
+func TestSanitizeProfiles(t *testing.T) {
+
+	page := `<html><head><meta http-equiv="refresh" content="0;url=file:///etc/passwd"><style>body{background:url(file:///etc/passwd)}</style></head>` +
+		`<body onload="alert(1)"><script>alert(2)</script><a href=" javascript:alert(3)">a</a>` +
+		`<img src="http://evil.com/x.png"><iframe src="x"><p>x</p></iframe><p>hello</p></body></html>`
+
+	cases := []struct {
+		Profile string
+		Expect  string
+	}{
+		{ProfileNone, page},
+		{ProfileBasic, `<html><head><style>body{background:url()}</style></head>` +
+			`<body><a>a</a><img src="http://evil.com/x.png"><iframe src="x"><p>x</p></iframe><p>hello</p></body></html>`},
+		{ProfileStrict, `<html><head><style>body{background:url()}</style></head>` +
+			`<body><a>a</a><img><p>hello</p></body></html>`},
+	}
+
+	for _, c := range cases {
+		policy, err := Profile(c.Profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := policy.SanitizeBytes([]byte(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != c.Expect {
+			t.Errorf("profile %s: unexpected document %s", c.Profile, data)
+		}
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+
+	policy, err := NewPolicy(config.NewConfig(config.ConfigString(`
+		profile       = strict
+		allowed-hosts = ["cdn.example.com"]`)), ProfileNone)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.allowURL("https://cdn.example.com/a.css") || policy.allowURL("https://evil.com/a.css") {
+		t.Errorf("unexpected allowed hosts %v", policy.AllowedHosts)
+	}
+
+	if args := policy.RendererArgs(); len(args) != 2 {
+		t.Errorf("unexpected renderer args %v", args)
+	}
+
+	_, err = NewPolicy(config.NewConfig(config.ConfigString(`profile = unknown`)), ProfileNone)
+	if err == nil {
+		t.Errorf("expected unknown profile error")
+	}
+}
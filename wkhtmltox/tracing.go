@@ -0,0 +1,24 @@
+package wkhtmltox
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/gogap/go-wkhtmltox/wkhtmltox"
+
+// the spans are dropped until the application sets a tracer provider by
+// otel.SetTracerProvider, as the server does by service.tracing, or by
+// WKHtmlToX.SetTracerProvider
+var tracer = otel.Tracer(tracerName)
+
+// endSpan records the error and its kind to the span, then ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, string(ErrorKindOf(err)))
+	}
+
+	span.End()
+}
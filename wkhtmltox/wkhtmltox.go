@@ -1,9 +1,14 @@
 package wkhtmltox
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -12,8 +17,12 @@ import (
 
 	"github.com/gogap/config"
 	"github.com/pborman/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/logging"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/sanitize"
 )
 
 type ToFormat string
@@ -160,7 +169,7 @@ func (p *ToPDFOptions) toCommandArgs() []string {
 	}
 
 	if p.GrayScale {
-		args = append(args, "--gray-scale")
+		args = append(args, "--grayscale")
 	}
 
 	if p.LowQuality {
@@ -187,20 +196,88 @@ func (p *ToPDFOptions) toCommandArgs() []string {
 }
 
 type FetcherOptions struct {
-	Name   string          `json:"name"`   // http, oss, data
-	Params json.RawMessage `json:"params"` // Optional
+	Name     string          `json:"name"`     // http, oss, data
+	Params   json.RawMessage `json:"params"`   // Optional
+	Sanitize string          `json:"sanitize"` // Optional, none, basic or strict, could not be weaker than the fetcher's
 }
 
 type WKHtmlToX struct {
-	verbose  bool
-	timeout  time.Duration
-	fetchers map[string]fetcher.Fetcher
+	verbose     bool
+	timeout     time.Duration
+	errorPolicy string
+	fetchers    map[string]fetcher.StreamFetcher
+	policies    map[string]*sanitize.Policy
+	sandbox     *sandbox
+	executor    Executor
+	workDir     *workDir
+	binaries    *binaries
+	queue       *queue
+	health      *health
+	limits      limits
+	tracer      trace.Tracer
+
+	healthCheckers map[string]fetcher.HealthChecker
+}
+
+// the flags conflict with the sanitize policy, and the count of their values
+var sanitizeConflictArgs = map[string]int{
+	"--enable-javascript":        0,
+	"-n":                         0,
+	"--enable-local-file-access": 0,
+	"--allow":                    1,
+	"--enable-plugins":           0,
+	"--run-script":               1,
+}
+
+// the flags read or write local files, load urls, or change the requests of
+// the page, they are refused while the document is sanitized
+var sanitizeRejectArgs = map[string]bool{
+	"--cookie-jar":                true,
+	"--user-style-sheet":          true,
+	"--header-html":               true,
+	"--footer-html":               true,
+	"--post":                      true,
+	"--post-file":                 true,
+	"--custom-header":             true,
+	"--custom-header-propagation": true,
+	"--cookie":                    true,
+	"--xsl-style-sheet":           true,
+	"--checkbox-svg":              true,
+	"--checkbox-checked-svg":      true,
+	"--radiobutton-svg":           true,
+	"--radiobutton-checked-svg":   true,
+	"--ssl-crt-path":              true,
+	"--ssl-key-path":              true,
+	"--ssl-key-password":          true,
+	"--proxy":                     true,
+	"--bypass-proxy-for":          true,
+	"--cache-dir":                 true,
+	"--dump-outline":              true,
+	"--dump-default-toc-xsl":      true,
+	"--read-args-from-stdin":      true,
+	"--username":                  true,
+	"--password":                  true,
+}
+
+// checkSanitizeArgs refuses the flags of sanitizeRejectArgs
+func checkSanitizeArgs(args []string) (err error) {
+	for _, arg := range args {
+		if sanitizeRejectArgs[arg] {
+			err = newError(ErrInvalidOptions, fmt.Errorf("option %s is not allowed while the document is sanitized", arg))
+			return
+		}
+	}
+	return
 }
 
 func New(conf config.Configuration) (wkHtmlToX *WKHtmlToX, err error) {
 
 	wk := &WKHtmlToX{
-		fetchers: make(map[string]fetcher.Fetcher),
+		fetchers: make(map[string]fetcher.StreamFetcher),
+		policies: make(map[string]*sanitize.Policy),
+		tracer:   tracer,
+
+		healthCheckers: make(map[string]fetcher.HealthChecker),
 	}
 
 	commandTimeout := conf.GetTimeDuration("timeout", time.Second*300)
@@ -211,14 +288,46 @@ func New(conf config.Configuration) (wkHtmlToX *WKHtmlToX, err error) {
 
 	wk.verbose = verbose
 
-	fetchersConf := conf.GetConfig("fetchers")
+	wk.errorPolicy = conf.GetString("error-policy", ErrorPolicyStrict)
 
-	if fetchersConf == nil || len(fetchersConf.Keys()) == 0 {
-		wkHtmlToX = wk
+	err = checkErrorPolicy(wk.errorPolicy)
+	if err != nil {
 		return
 	}
 
-	fetcherList := fetchersConf.Keys()
+	wk.sandbox, err = newSandbox(conf.GetConfig("sandbox"))
+	if err != nil {
+		return
+	}
+
+	wk.executor = &processExecutor{sandbox: wk.sandbox}
+
+	wk.workDir, err = newWorkDir(conf.GetConfig("workdir"))
+	if err != nil {
+		return
+	}
+
+	wk.queue = newQueue(conf.GetConfig("queue"))
+	wk.limits = newLimits(conf.GetConfig("limits"))
+	wk.health = newHealth(conf.GetConfig("health"))
+
+	binariesConf := conf.GetConfig("binaries")
+
+	wk.binaries = newBinaries(binariesConf)
+
+	if binariesConf == nil || binariesConf.GetBoolean("probe", true) {
+		err = wk.Probe(context.Background())
+		if err != nil {
+			return
+		}
+	}
+
+	fetchersConf := conf.GetConfig("fetchers")
+
+	var fetcherList []string
+	if fetchersConf != nil {
+		fetcherList = fetchersConf.Keys()
+	}
 
 	for _, fName := range fetcherList {
 
@@ -244,14 +353,45 @@ func New(conf config.Configuration) (wkHtmlToX *WKHtmlToX, err error) {
 
 		fOptions := fetcherConf.GetConfig("options")
 
-		var f fetcher.Fetcher
-		f, err = fetcher.New(fDriver, fOptions)
+		var f fetcher.StreamFetcher
+		f, err = fetcher.NewStream(fDriver, fOptions)
+
+		if err != nil {
+			return
+		}
+
+		var middlewares []fetcher.Middleware
+		middlewares, err = newMiddlewares(fetcherConf)
+
+		if err != nil {
+			err = fmt.Errorf("the fetcher of %s's middleware is illegal, %s", fName, err.Error())
+			return
+		}
+
+		if hc, ok := fetcher.AsHealthChecker(f); ok {
+			wk.healthCheckers[fName] = hc
+		}
+
+		f = fetcher.Chain(f, middlewares...)
+
+		var policy *sanitize.Policy
+		policy, err = sanitize.NewPolicy(fetcherConf.GetConfig("sanitize"), sanitize.ProfileNone)
 
 		if err != nil {
+			err = fmt.Errorf("the fetcher of %s's sanitize is illegal, %s", fName, err.Error())
 			return
 		}
 
 		wk.fetchers[fName] = f
+		wk.policies[fName] = policy
+	}
+
+	// the goroutines are started after all the options are checked, they are
+	// stopped by Close
+	wk.workDir.start()
+
+	if wk.health.interval > 0 {
+		go wk.canary()
 	}
 
 	wkHtmlToX = wk
@@ -259,7 +399,76 @@ func New(conf config.Configuration) (wkHtmlToX *WKHtmlToX, err error) {
 	return
 }
 
+func newMiddlewares(fetcherConf config.Configuration) (middlewares []fetcher.Middleware, err error) {
+
+	names := fetcherConf.GetStringList("middlewares")
+
+	if len(names) == 0 {
+		return
+	}
+
+	optionsConf := fetcherConf.GetConfig("middleware-options")
+
+	for _, name := range names {
+
+		var mConf config.Configuration
+		if optionsConf != nil {
+			mConf = optionsConf.GetConfig(name)
+		}
+
+		var m fetcher.Middleware
+		m, err = fetcher.NewMiddleware(name, mConf)
+
+		if err != nil {
+			return
+		}
+
+		middlewares = append(middlewares, m)
+	}
+
+	return
+}
+
+// Close stops the background goroutines, the janitor of the working dirs and
+// the canary
+func (p *WKHtmlToX) Close() {
+	p.workDir.close()
+	p.health.close()
+}
+
+// SetExecutor replaces the default executor which runs wkhtmltox as a child
+// process, e.g. by a fake executor in tests
+func (p *WKHtmlToX) SetExecutor(executor Executor) {
+	p.executor = executor
+}
+
+// SetTracerProvider replaces the global tracer provider of otel for the
+// spans of the conversions, e.g. by a span recorder in tests
+func (p *WKHtmlToX) SetTracerProvider(provider trace.TracerProvider) {
+	p.tracer = provider.Tracer(tracerName)
+}
+
 func (p *WKHtmlToX) Convert(fetcherOpts FetcherOptions, convertOpts ConvertOptions) (ret []byte, err error) {
+	return p.ConvertContext(context.Background(), fetcherOpts, convertOpts)
+}
+
+// ConvertContext is the same as Convert, the fetching and converting will be
+// canceled while ctx is done
+func (p *WKHtmlToX) ConvertContext(ctx context.Context, fetcherOpts FetcherOptions, convertOpts ConvertOptions) (ret []byte, err error) {
+
+	result, err := p.ConvertResult(ctx, fetcherOpts, convertOpts)
+	if err != nil {
+		return
+	}
+
+	ret = result.Data
+
+	return
+}
+
+// ConvertResult is the same as ConvertContext, and returns the warnings of
+// the partial success by the error policy
+func (p *WKHtmlToX) ConvertResult(ctx context.Context, fetcherOpts FetcherOptions, convertOpts ConvertOptions) (ret *Result, err error) {
 
 	cmd := ""
 	ext := ""
@@ -279,79 +488,365 @@ func (p *WKHtmlToX) Convert(fetcherOpts FetcherOptions, convertOpts ConvertOptio
 			ext = ".pdf"
 		}
 	default:
-		err = fmt.Errorf("unkown ConvertOptions type")
+		err = newError(ErrInvalidOptions, fmt.Errorf("unkown ConvertOptions type"))
+		return
+	}
+
+	bin := p.binaries.get(cmd)
+	target := targetOf(cmd)
+
+	ctx, span := p.tracer.Start(ctx, "wkhtmltox.convert", trace.WithAttributes(
+		attribute.String("wkhtmltox.target", target),
+		attribute.String("wkhtmltox.fetcher", p.fetcherLabel(fetcherOpts.Name)),
+	))
+
+	defer func() {
+		conversionsTotal.WithLabelValues(target, p.fetcherLabel(fetcherOpts.Name), errorLabel(err)).Inc()
+		endSpan(span, err)
+	}()
+
+	args := convertOpts.toCommandArgs()
+
+	err = bin.check(args)
+	if err != nil {
 		return
 	}
 
 	inputMethod := convertOpts.uri()
 
-	var data []byte
+	policy, err := p.sanitizePolicy(fetcherOpts)
+	if err != nil {
+		err = newError(ErrInvalidOptions, err)
+		return
+	}
+
+	if policy.Enabled() {
+		err = checkSanitizeArgs(args)
+		if err != nil {
+			return
+		}
+	}
+
+	useFetcher := len(fetcherOpts.Name) > 0 && fetcherOpts.Name != "default"
+
+	if len(inputMethod) == 0 && !useFetcher {
+		err = newError(ErrInvalidOptions, fmt.Errorf("non input method could be use, please check your fetcher options or uri param"))
+		return
+	}
+
+	// the slot is acquired before fetching, the streaming document is not
+	// kept open while waiting
+	queueStart := time.Now()
+
+	_, queueSpan := p.tracer.Start(ctx, "wkhtmltox.queue.wait")
+
+	release, err := p.queue.acquire(ctx)
+
+	endSpan(queueSpan, err)
+
+	if err != nil {
+		return
+	}
+
+	defer release()
+
+	queueWaitSeconds.WithLabelValues(target).Observe(time.Since(queueStart).Seconds())
+
+	var input io.Reader
+	var limited *limitedReader
+	var fetchDuration time.Duration
+
+	if useFetcher {
 
-	if len(fetcherOpts.Name) > 0 && fetcherOpts.Name != "default" {
+		fetchStart := time.Now()
 
-		data, err = p.fetch(fetcherOpts)
+		var doc *fetcher.Document
+		doc, err = p.fetch(ctx, fetcherOpts)
 		if err != nil {
 			return
 		}
 
-		inputMethod = "-"
+		defer doc.Close()
+
+		limited, err = p.limits.input(doc)
+		if err != nil {
+			return
+		}
+
+		if policy.Enabled() {
+			// the local file is untrusted as well, sanitize it and send by stdin
+			buf := bytes.NewBuffer(nil)
+			err = policy.Sanitize(limited, buf)
+			if limited.exceeded {
+				err = errInputTooLarge(limited.limit)
+				return
+			}
+			if err != nil {
+				err = fmt.Errorf("sanitize document failure, %s", err.Error())
+				return
+			}
+			input = buf
+			inputMethod = "-"
+		} else if len(doc.LocalPath) > 0 && p.sandbox == nil {
+			inputMethod = doc.LocalPath
+		} else {
+			// the local file may not be visible in the sandbox, send it by stdin as well
+			input = limited
+			inputMethod = "-"
+		}
+
+		// the streaming document is still read while rendering
+		fetchDuration = time.Since(fetchStart)
+
+		fetchDurationSeconds.WithLabelValues(p.fetcherLabel(fetcherOpts.Name)).Observe(fetchDuration.Seconds())
 	}
 
-	if len(inputMethod) == 0 {
-		err = fmt.Errorf("non input method could be use, please check your fetcher options or uri param")
+	ret, err = p.render(ctx, renderJob{
+		bin:         bin,
+		ext:         ext,
+		args:        args,
+		inputMethod: inputMethod,
+		input:       input,
+		policy:      policy,
+	})
+
+	// wkhtmltox fails while its stdin is broken by the limit
+	if limited != nil && limited.exceeded {
+		ret, err = nil, errInputTooLarge(limited.limit)
+	}
+
+	if err != nil {
 		return
 	}
 
-	tmpDir, err := ioutil.TempDir("", "go-wkhtmltox")
+	ret.FetchDuration = fetchDuration
+
+	renderDurationSeconds.WithLabelValues(target).Observe(ret.RenderDuration.Seconds())
+	outputBytes.WithLabelValues(target).Observe(float64(ret.Size))
+
+	return
+}
+
+type renderJob struct {
+	bin         *binary
+	ext         string
+	args        []string
+	inputMethod string
+	input       io.Reader
+	policy      *sanitize.Policy
+}
+
+// render executes wkhtmltox in a private working dir, and reads the result
+func (p *WKHtmlToX) render(ctx context.Context, job renderJob) (ret *Result, err error) {
+
+	tmpDir, err := p.workDir.create()
 	if err != nil {
 		return
 	}
 
-	tmpfileName := filepath.Join(tmpDir, uuid.New()) + ext
+	defer p.workDir.remove(tmpDir)
 
-	args := convertOpts.toCommandArgs()
+	tmpfileName := filepath.Join(tmpDir, uuid.New()) + job.ext
+
+	args := job.args
+	inputMethod := job.inputMethod
+
+	if job.policy.Enabled() {
+		args = append(removeArgs(args, sanitizeConflictArgs), job.policy.RendererArgs()...)
+	}
+
+	args = errorPolicyArgs(p.errorPolicy, args)
 
-	if p.verbose {
+	progress := progressFromContext(ctx)
+
+	// the progress and the warnings are printed to the console without --quiet
+	if p.verbose || progress != nil || p.errorPolicy != ErrorPolicyStrict {
 		args = append(args, []string{inputMethod, tmpfileName}...)
 	} else {
 		args = append(args, []string{"--quiet", inputMethod, tmpfileName}...)
 	}
 
+	renderStart := time.Now()
+
+	processesInFlight.Inc()
+
+	execCtx, span := p.tracer.Start(ctx, "wkhtmltox.process", trace.WithAttributes(
+		attribute.String("wkhtmltox.binary", job.bin.name),
+		attribute.String("wkhtmltox.version", job.bin.version),
+	))
+
+	maxOutputSize := p.limits.outputSize(ctx)
+
+	// wkhtmltox exits 0 while the media failed to load, the warnings are
+	// only printed to the console
+	var consoleWarnings []string
+
 	var output []byte
-	output, err = execCommand(p.timeout, data, cmd, args...)
+	output, err = p.executor.Execute(execCtx, Command{
+		Name:          job.bin.path,
+		Args:          args,
+		Dir:           tmpDir,
+		Output:        tmpfileName,
+		Input:         job.input,
+		Timeout:       p.timeout,
+		MaxOutputSize: maxOutputSize,
+		Progress:      progress,
+		OnExit:        observePeakRSS(targetOf(job.bin.name)),
+		OnStderr:      func(stderr string) { consoleWarnings = parseWarnings(stderr) },
+	})
+
+	processesInFlight.Dec()
+
+	var e *Error
+	if errors.As(err, &e) && e.ExitCode != 0 {
+		span.SetAttributes(attribute.Int("wkhtmltox.exit_code", e.ExitCode))
+	}
 
-	if p.verbose {
-		if len(output) > 0 {
-			fmt.Println("[wkhtmltox][DBG]", string(output))
+	endSpan(span, err)
+
+	// the logger of the request carries the request id, so the console
+	// output could be found by the id returned to the client
+	logger := logging.FromContext(ctx).With("binary", job.bin.name)
+
+	// verbose raises the console output to info, so it is logged without
+	// enabling the debug logs of the service
+	if len(output) > 0 {
+		level := slog.LevelDebug
+		if p.verbose {
+			level = slog.LevelInfo
 		}
+		logger.Log(ctx, level, "renderer output", "output", string(output))
+	}
 
-		if err != nil {
-			fmt.Println("[wkhtmltox][ERR]", err)
+	if err != nil {
+		attrs := []any{"error", err}
+		if errors.As(err, &e) {
+			attrs = append(attrs, "exit_code", e.ExitCode, "stderr", e.Stderr)
 		}
+		logger.Warn("renderer failure", attrs...)
 	}
 
+	renderDuration := time.Since(renderStart)
+
+	var warnings []string
+
+	if p.errorPolicy != ErrorPolicyStrict {
+		warnings = consoleWarnings
+	}
+
+	if err != nil {
+		var tolerated bool
+		warnings, tolerated = tolerate(p.errorPolicy, err)
+
+		if !tolerated || !fileExist(tmpfileName) {
+			err = classify(ErrRendererFailed, err)
+			return
+		}
+	}
+
+	err = checkOutputSize(tmpfileName, maxOutputSize)
+	if err != nil {
+		return
+	}
+
+	var data []byte
+	data, err = ioutil.ReadFile(tmpfileName)
 	if err != nil {
 		return
 	}
 
-	defer os.Remove(tmpfileName)
+	ret = &Result{
+		Data:           data,
+		Warnings:       warnings,
+		RenderDuration: renderDuration,
+		Version:        job.bin.version,
+	}
+
+	ret.parseMetadata(job.ext)
+
+	err = checkPages(ret.Pages, p.limits.maxPages)
+	if err != nil {
+		ret = nil
+		return
+	}
+
+	return
+}
+
+func fileExist(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Size() > 0
+}
+
+// sanitizePolicy returns the policy of the fetcher, or the profile of the
+// request while it is stricter than the fetcher's
+func (p *WKHtmlToX) sanitizePolicy(fetcherOpts FetcherOptions) (policy *sanitize.Policy, err error) {
 
-	var result []byte
-	result, err = ioutil.ReadFile(tmpfileName)
+	policy = p.policies[fetcherOpts.Name]
 
-	ret = result
+	if len(fetcherOpts.Sanitize) == 0 {
+		return
+	}
+
+	reqPolicy, err := sanitize.Profile(fetcherOpts.Sanitize)
+	if err != nil {
+		return
+	}
+
+	if policy == nil || reqPolicy.Level > policy.Level {
+		policy = reqPolicy
+	}
 
 	return
 }
 
-func (p *WKHtmlToX) fetch(fetcherOpts FetcherOptions) (data []byte, err error) {
-	fetcher, exist := p.fetchers[fetcherOpts.Name]
+func removeArgs(args []string, flags map[string]int) []string {
+	var ret []string
+
+	for i := 0; i < len(args); i++ {
+		n, exist := flags[args[i]]
+		if !exist {
+			ret = append(ret, args[i])
+			continue
+		}
+
+		i += n
+	}
+
+	return ret
+}
+
+// HasFetcher reports whether the fetcher of name is configured
+func (p *WKHtmlToX) HasFetcher(name string) bool {
+	_, exist := p.fetchers[name]
+	return exist
+}
+
+func (p *WKHtmlToX) fetch(ctx context.Context, fetcherOpts FetcherOptions) (doc *fetcher.Document, err error) {
+	ctx, span := p.tracer.Start(ctx, "wkhtmltox.fetch", trace.WithAttributes(
+		attribute.String("wkhtmltox.fetcher", fetcherOpts.Name),
+	))
+
+	defer func() { endSpan(span, err) }()
+
+	f, exist := p.fetchers[fetcherOpts.Name]
 	if !exist {
-		err = fmt.Errorf("fetcher %s not exist", fetcherOpts.Name)
+		err = newError(ErrInvalidOptions, fmt.Errorf("fetcher %s not exist", fetcherOpts.Name))
 		return
 	}
 
-	data, err = fetcher.Fetch([]byte(fetcherOpts.Params))
+	doc, err = f.FetchStream(ctx, fetcher.FetchParams(fetcherOpts.Params))
+
+	var paramsErr *fetcher.ParamsError
+	if errors.As(err, &paramsErr) {
+		err = newError(ErrInvalidOptions, err)
+		return
+	}
+
+	if err != nil {
+		err = classify(ErrFetchFailed, err)
+		return
+	}
 
 	return
 }
@@ -0,0 +1,278 @@
+package wkhtmltox_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogap/config"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+	"github.com/gogap/go-wkhtmltox/wkhtmltox/wkhtmltoxtest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	_ "github.com/gogap/go-wkhtmltox/wkhtmltox/fetcher/data"
+)
+
+func newWKHtmlToX(t *testing.T, result string, options ...string) (*wkhtmltox.WKHtmlToX, *wkhtmltoxtest.FakeExecutor) {
+
+	wk, err := wkhtmltox.New(config.NewConfig(config.ConfigString(strings.Join(options, "\n") + `
+		binaries {
+			probe = false
+		}
+
+		fetchers {
+			data {
+				driver = data
+				options {}
+			}
+
+			untrusted {
+				driver = data
+				options {}
+
+				sanitize {
+					profile = basic
+				}
+			}
+		}`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(wk.Close)
+
+	executor := wkhtmltoxtest.NewFakeExecutor([]byte(result))
+	wk.SetExecutor(executor)
+
+	return wk, executor
+}
+
+func dataParams(html string) json.RawMessage {
+	params, _ := json.Marshal(map[string][]byte{"data": []byte(html)})
+	return params
+}
+
+func TestConvertURI(t *testing.T) {
+
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Pages /Count 2 >>\n2 0 obj << /Type /Page >>\n3 0 obj << /Type/Page/Parent 1 0 R >>"
+
+	dir, binaries := fakeBinaries(t)
+
+	wk, executor := newWKHtmlToX(t, pdf, binaries)
+	executor.Console = []byte("wkhtmltopdf 0.12.6 (with patched qt)\n")
+
+	err := wk.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com", PageSize: "A4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(result.Data) != pdf || result.ContentType != "application/pdf" || result.Size != len(pdf) ||
+		result.Pages != 2 || result.Version != "0.12.6 (with patched qt)" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	call, _ := executor.LastCall()
+
+	args := strings.Join(call.Args, " ")
+
+	if call.Name != filepath.Join(dir, "wkhtmltopdf") || !strings.HasPrefix(args, "--page-size A4 --quiet https://example.com ") {
+		t.Errorf("unexpected command %s %s", call.Name, args)
+	}
+}
+
+func TestConvertFetcher(t *testing.T) {
+
+	wk, executor := newWKHtmlToX(t, "PNG")
+
+	page := `<html><body onload="alert(1)"><p>hello</p></body></html>`
+
+	_, err := wk.Convert(wkhtmltox.FetcherOptions{Name: "data", Params: dataParams(page)},
+		&wkhtmltox.ToImageOptions{Format: "png", Extend: wkhtmltox.ExtendParams{"enable-javascript": ""}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := executor.LastCall()
+
+	if call.Name != "wkhtmltoimage" || string(call.Stdin) != page {
+		t.Errorf("unexpected command %s, stdin %s", call.Name, call.Stdin)
+	}
+
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{Name: "untrusted", Params: dataParams(page)},
+		&wkhtmltox.ToImageOptions{Format: "png", Extend: wkhtmltox.ExtendParams{"enable-javascript": ""}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ = executor.LastCall()
+
+	args := strings.Join(call.Args, " ")
+
+	if string(call.Stdin) != `<html><body><p>hello</p></body></html>` {
+		t.Errorf("document is not sanitized, %s", call.Stdin)
+	}
+
+	if strings.Contains(args, "--enable-javascript") || !strings.Contains(args, "--disable-javascript") {
+		t.Errorf("unexpected args %s", args)
+	}
+
+	if len(executor.Calls()) != 2 {
+		t.Errorf("unexpected calls %d", len(executor.Calls()))
+	}
+}
+
+func TestConvertPartialSuccess(t *testing.T) {
+
+	loadErr := &wkhtmltox.Error{
+		Kind:     wkhtmltox.ErrNetworkErrorInPage,
+		ExitCode: 1,
+		Stderr:   "Warning: Failed to load http://example.com/a.png, with network status code 203\nExit with code 1 due to network error: ContentNotFoundError",
+	}
+
+	wk, executor := newWKHtmlToX(t, "%PDF-1.4")
+	executor.Err = loadErr
+
+	_, err := wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com"})
+	if wkhtmltox.ErrorKindOf(err) != wkhtmltox.ErrNetworkErrorInPage {
+		t.Errorf("expected network error in strict policy, got %v", err)
+	}
+
+	wk, executor = newWKHtmlToX(t, "%PDF-1.4", "error-policy = tolerate-load-errors")
+	executor.Err = loadErr
+
+	result, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(result.Data) != "%PDF-1.4" || len(result.Warnings) != 2 {
+		t.Errorf("unexpected result %s, warnings %v", result.Data, result.Warnings)
+	}
+
+	call, _ := executor.LastCall()
+	if args := strings.Join(call.Args, " "); !strings.Contains(args, "--load-error-handling ignore --load-media-error-handling ignore") {
+		t.Errorf("unexpected args %s", args)
+	}
+
+	loadErr.ExitCode = 2
+
+	_, err = wk.Convert(wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{URI: "https://example.com"})
+	if err == nil {
+		t.Errorf("expected failure of exit code 2")
+	}
+
+	// the media failed to load with exit code 0, and the handling of the
+	// user is kept
+	executor.Err = nil
+	executor.Stderr = "Loading pages (1/6)\nWarning: Failed to load http://example.com/a.png, with network status code 203\n"
+
+	result, err = wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{}, &wkhtmltox.ToPDFOptions{
+		URI:    "https://example.com",
+		Extend: wkhtmltox.ExtendParams{"load-error-handling": "abort"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Warnings) != 1 || !strings.HasPrefix(result.Warnings[0], "Warning: Failed to load") {
+		t.Errorf("unexpected warnings %v", result.Warnings)
+	}
+
+	call, _ = executor.LastCall()
+	if args := strings.Join(call.Args, " "); !strings.Contains(args, "--load-error-handling abort") ||
+		strings.Contains(args, "--load-error-handling ignore") || !strings.Contains(args, "--load-media-error-handling ignore") {
+		t.Errorf("unexpected args %s", args)
+	}
+}
+
+func TestConvertSpans(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	wk, _ := newWKHtmlToX(t, "%PDF-1.4")
+	wk.SetTracerProvider(provider)
+
+	_, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{Name: "data", Params: dataParams("<p>hello</p>")}, &wkhtmltox.ToPDFOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	var traceIDs = map[string]bool{}
+
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		traceIDs[span.SpanContext().TraceID().String()] = true
+	}
+
+	expected := "wkhtmltox.queue.wait wkhtmltox.fetch wkhtmltox.process wkhtmltox.convert"
+
+	if strings.Join(names, " ") != expected || len(traceIDs) != 1 {
+		t.Errorf("unexpected spans %v", names)
+	}
+}
+
+func TestConvertLimits(t *testing.T) {
+
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Page >>\n2 0 obj << /Type /Page >>"
+
+	cases := []struct {
+		limits string
+		ctx    context.Context
+		kind   wkhtmltox.ErrorKind
+	}{
+		{"max-input-size = 4", context.Background(), wkhtmltox.ErrInputTooLarge},
+		{"max-output-size = 16", context.Background(), wkhtmltox.ErrOutputTooLarge},
+		{"max-output-size = 1024", wkhtmltox.WithMaxOutputSize(context.Background(), 16), wkhtmltox.ErrOutputTooLarge},
+		{"max-pages = 1", context.Background(), wkhtmltox.ErrTooManyPages},
+		{"max-input-size = 1024, max-output-size = 1024, max-pages = 2", context.Background(), ""},
+	}
+
+	for _, c := range cases {
+		wk, _ := newWKHtmlToX(t, pdf, "limits {"+c.limits+"}")
+
+		_, err := wk.ConvertResult(c.ctx, wkhtmltox.FetcherOptions{Name: "data", Params: dataParams("<p>hello</p>")}, &wkhtmltox.ToPDFOptions{})
+
+		if wkhtmltox.ErrorKindOf(err) != c.kind {
+			t.Errorf("limits %s: expected %q, got %v", c.limits, c.kind, err)
+		}
+	}
+}
+
+func TestConvertSanitizeRejectArgs(t *testing.T) {
+
+	wk, executor := newWKHtmlToX(t, "%PDF-1.4")
+
+	for _, flag := range []string{"cookie-jar", "user-style-sheet", "header-html", "footer-html", "post-file", "custom-header"} {
+		_, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{Name: "untrusted", Params: dataParams("<p>hello</p>")},
+			&wkhtmltox.ToPDFOptions{Extend: wkhtmltox.ExtendParams{flag: "file:///etc/passwd"}})
+
+		if wkhtmltox.ErrorKindOf(err) != wkhtmltox.ErrInvalidOptions {
+			t.Errorf("extend %s should be refused, got %v", flag, err)
+		}
+	}
+
+	if len(executor.Calls()) != 0 {
+		t.Errorf("wkhtmltox should not be executed, %d calls", len(executor.Calls()))
+	}
+
+	// the flags are allowed while the document is not sanitized
+	_, err := wk.ConvertResult(context.Background(), wkhtmltox.FetcherOptions{Name: "data", Params: dataParams("<p>hello</p>")},
+		&wkhtmltox.ToPDFOptions{Extend: wkhtmltox.ExtendParams{"cookie-jar": "cookies.txt"}})
+
+	if err != nil {
+		t.Error(err)
+	}
+}
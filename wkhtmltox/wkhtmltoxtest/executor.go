@@ -0,0 +1,97 @@
+package wkhtmltoxtest
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"github.com/gogap/go-wkhtmltox/wkhtmltox"
+)
+
+// Call is a command recorded by FakeExecutor
+type Call struct {
+	Name  string
+	Args  []string
+	Stdin []byte
+}
+
+// FakeExecutor records the commands and writes the canned result to the
+// output file instead of running wkhtmltox
+type FakeExecutor struct {
+	Result  []byte // Written to the output file if both of them are not empty
+	Console []byte // Returned as the console output
+	Stderr  string // Passed to Command.OnStderr while Err is nil
+	Err     error  // Returned after writing the result, e.g. *wkhtmltox.Error of exit code 1
+
+	locker sync.Mutex
+	calls  []Call
+}
+
+func NewFakeExecutor(result []byte) *FakeExecutor {
+	return &FakeExecutor{
+		Result: result,
+	}
+}
+
+func (p *FakeExecutor) Execute(ctx context.Context, cmd wkhtmltox.Command) (output []byte, err error) {
+
+	call := Call{
+		Name: cmd.Name,
+		Args: append([]string(nil), cmd.Args...),
+	}
+
+	if cmd.Input != nil {
+		call.Stdin, err = ioutil.ReadAll(cmd.Input)
+		if err != nil {
+			return
+		}
+	}
+
+	p.locker.Lock()
+	p.calls = append(p.calls, call)
+	p.locker.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if p.Result != nil && len(cmd.Output) > 0 {
+		err = ioutil.WriteFile(cmd.Output, p.Result, 0644)
+		if err != nil {
+			return
+		}
+	}
+
+	if p.Err != nil {
+		err = p.Err
+		return
+	}
+
+	if cmd.OnStderr != nil {
+		cmd.OnStderr(p.Stderr)
+	}
+
+	output = p.Console
+
+	return
+}
+
+// Calls returns the recorded commands in order
+func (p *FakeExecutor) Calls() []Call {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	return append([]Call(nil), p.calls...)
+}
+
+// LastCall returns the latest recorded command
+func (p *FakeExecutor) LastCall() (call Call, exist bool) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	if len(p.calls) == 0 {
+		return
+	}
+
+	return p.calls[len(p.calls)-1], true
+}
@@ -0,0 +1,163 @@
+package wkhtmltox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+const jobDirPrefix = "job-"
+
+// workDir manages the private working dirs of the converting jobs under
+// root, the root could be shared by the other instances, only the job dirs
+// older than max-age are removed
+type workDir struct {
+	root           string
+	maxAge         time.Duration
+	minFree        uint64
+	minFreePercent float64
+
+	interval time.Duration // the interval of the janitor
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newWorkDir creates the root and removes the leftovers, options:
+//
+//	root             = "/tmp/go-wkhtmltox"
+//	janitor-interval = 1m
+//	max-age          = 1h        # the job dirs older than it are removed by janitor
+//	min-free-space   = 536870912 # bytes, refuse new jobs below it, default is 0
+//	min-free-percent = 5
+func newWorkDir(conf config.Configuration) (wd *workDir, err error) {
+
+	wd = &workDir{
+		root:           filepath.Join(os.TempDir(), "go-wkhtmltox"),
+		maxAge:         time.Hour,
+		minFreePercent: 5,
+		interval:       time.Minute,
+		stop:           make(chan struct{}),
+	}
+
+	if conf != nil {
+		wd.root = conf.GetString("root", wd.root)
+		wd.maxAge = conf.GetTimeDuration("max-age", wd.maxAge)
+		wd.minFree = uint64(conf.GetInt64("min-free-space", int64(wd.minFree)))
+		wd.minFreePercent = conf.GetFloat64("min-free-percent", wd.minFreePercent)
+		wd.interval = conf.GetTimeDuration("janitor-interval", wd.interval)
+	}
+
+	// the job dirs could be entered by the sandbox user
+	err = os.MkdirAll(wd.root, 0711)
+	if err != nil {
+		err = fmt.Errorf("[workdir]: %s", err.Error())
+		return
+	}
+
+	// the leftovers of the crashed instances, the dirs of the running jobs of
+	// the other instances are younger than max-age
+	wd.sweep(wd.maxAge)
+
+	return
+}
+
+// create creates the private dir of a job, it is refused while the volume
+// is nearly full
+func (p *workDir) create() (dir string, err error) {
+
+	free, percent, err := diskFree(p.root)
+	if err != nil {
+		err = fmt.Errorf("[workdir]: %s", err.Error())
+		return
+	}
+
+	if free < p.minFree || percent < p.minFreePercent {
+		err = newError(ErrInsufficientStorage, fmt.Errorf("[workdir]: free space of %s is %d bytes (%.1f%%), refuse new jobs", p.root, free, percent))
+		return
+	}
+
+	return ioutil.TempDir(p.root, jobDirPrefix)
+}
+
+func (p *workDir) remove(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("[workdir]: remove %s failure, %s\n", dir, err.Error())
+	}
+}
+
+// sweep removes the job dirs older than maxAge
+func (p *workDir) sweep(maxAge time.Duration) {
+
+	entries, err := ioutil.ReadDir(p.root)
+	if err != nil {
+		log.Printf("[workdir]: read %s failure, %s\n", p.root, err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), jobDirPrefix) {
+			continue
+		}
+
+		if time.Since(entry.ModTime()) < maxAge {
+			continue
+		}
+
+		p.remove(filepath.Join(p.root, entry.Name()))
+	}
+}
+
+// start starts the janitor, it is stopped by close
+func (p *workDir) start() {
+	if p.interval > 0 {
+		go p.janitor()
+	}
+}
+
+func (p *workDir) janitor() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweep(p.maxAge)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// close stops the janitor
+func (p *workDir) close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// diskFree returns the bytes and percent available for unprivileged users
+func diskFree(path string) (free uint64, percent float64, err error) {
+
+	stat := syscall.Statfs_t{}
+
+	err = syscall.Statfs(path, &stat)
+	if err != nil {
+		return
+	}
+
+	free = uint64(stat.Bavail) * uint64(stat.Bsize)
+
+	if stat.Blocks > 0 {
+		percent = float64(stat.Bavail) * 100 / float64(stat.Blocks)
+	} else {
+		percent = 100
+	}
+
+	return
+}
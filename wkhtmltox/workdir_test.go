@@ -0,0 +1,75 @@
+package wkhtmltox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogap/config"
+)
+
+func TestWorkDir(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "go-wkhtmltox-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	leftover := filepath.Join(root, jobDirPrefix+"leftover")
+	running := filepath.Join(root, jobDirPrefix+"running")
+	other := filepath.Join(root, "other")
+
+	os.MkdirAll(leftover, 0700)
+	os.MkdirAll(running, 0700)
+	os.MkdirAll(other, 0700)
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(leftover, old, old)
+
+	wd, err := newWorkDir(config.NewConfig(config.ConfigString(`
+		root             = "` + root + `"
+		janitor-interval = 0
+		max-age          = 1h
+		min-free-percent = 0`)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer wd.close()
+
+	if _, err = os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover is not removed")
+	}
+
+	if _, err = os.Stat(running); err != nil {
+		t.Errorf("the job dir younger than max-age is removed")
+	}
+
+	if _, err = os.Stat(other); err != nil {
+		t.Errorf("the dir not created by jobs is removed")
+	}
+
+	dir, err := wd.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "output.pdf"), []byte("%PDF"), 0644)
+
+	wd.remove(dir)
+
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("job dir is not removed")
+	}
+
+	wd.minFreePercent = 101
+
+	if _, err = wd.create(); ErrorKindOf(err) != ErrInsufficientStorage {
+		t.Errorf("expected insufficient storage, got %v", err)
+	}
+}